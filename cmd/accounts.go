@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+var newKeyFile string
+
+func init() {
+	rootCmd.AddCommand(accountsCmd)
+	accountsCmd.AddCommand(accountsListCmd)
+	accountsCmd.AddCommand(accountsRotateKeyCmd)
+
+	accountsRotateKeyCmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "Key file to encrypt with, a random key is generated if it does not exist")
+}
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Manage the encrypted token store",
+}
+
+var accountsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the accounts in the token store",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !storeExists() {
+			cmd.PrintErrln("There is no token store at", storePath())
+			os.Exit(1)
+		}
+		tokens, err := loadStore()
+		cobra.CheckErr(err)
+		for _, name := range slices.Sorted(maps.Keys(tokens)) {
+			cmd.Println(name)
+		}
+	},
+}
+
+var accountsRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Encrypt the token store with a new passphrase or key file",
+	Long: fmt.Sprintf(`Decrypt the token store with the current key and encrypt it with a new one.
+The current key is read from %s, %s or --key-file as usual.
+The new key is read from --new-key-file, or from the environment variable %s.`, passphraseEnv, keyFileEnv, newPassphraseEnv),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !storeExists() {
+			cmd.PrintErrln("There is no token store at", storePath())
+			os.Exit(1)
+		}
+		cobra.CheckErr(rotateStoreKey())
+		cmd.Println("Token store", storePath(), "is now encrypted with the new key.")
+		if newKeyFile != "" {
+			cmd.Println("Use --key-file", newKeyFile, "or", keyFileEnv+"="+newKeyFile, "to unlock it.")
+			if os.Getenv(passphraseEnv) != "" {
+				cmd.Println("Unset", passphraseEnv+", it is used instead of the key file and can not unlock the token store anymore.")
+			}
+		} else {
+			cmd.Println("Use", passphraseEnv, "with the new passphrase to unlock it.")
+		}
+	},
+}
+
+// rotateStoreKey decrypts the token store with the current key and encrypts all of its tokens with the new one
+func rotateStoreKey() error {
+	tokens, err := loadStore()
+	if err != nil {
+		return err
+	}
+	key, err := newStoreKey()
+	if err != nil {
+		return err
+	}
+	return saveStore(tokens, key)
+}
+
+// newPassphraseEnv is the passphrase rotate-key encrypts with
+const newPassphraseEnv = "TCPM_NEW_PASSPHRASE"
+
+// newStoreKey returns the key rotate-key encrypts with, generating the key file if needed
+func newStoreKey() ([]byte, error) {
+	if newKeyFile == "" {
+		passphrase := os.Getenv(newPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("set %s or pass --new-key-file", newPassphraseEnv)
+		}
+		return []byte(passphrase), nil
+	}
+
+	if _, err := os.Stat(newKeyFile); errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(newKeyFile, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+			return nil, fmt.Errorf("failed to write key file: %w", err)
+		}
+		fmt.Println("Generated a new key file", newKeyFile)
+	}
+	return readKeyFile(newKeyFile)
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate the config file",
+	Long:  "Check the config file for unknown keys and invalid values. Defaults to the config file used by the other commands.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := viper.ConfigFileUsed()
+		if len(args) > 0 {
+			path = args[0]
+		} else if path == "" {
+			path = "tcpm.yaml"
+		}
+
+		if err := checkConfigFile(path); err != nil {
+			cmd.PrintErrln(err)
+			os.Exit(1)
+		}
+		cmd.Println(path, "is valid")
+	},
+}
+
+// checkConfigFile returns an error for every problem of the config file, each referencing the line of the problem
+func checkConfigFile(path string) error {
+	problems, err := validateConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	errs := []error{}
+	for _, problem := range problems {
+		errs = append(errs, fmt.Errorf("%s:%w", path, problem))
+	}
+	return errors.Join(errs...)
+}
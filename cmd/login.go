@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
@@ -14,9 +17,8 @@ var (
 func init() {
 	rootCmd.AddCommand(loginCmd)
 
-	loginCmd.Flags().StringVarP(&username, "username", "u", "", "Twitch username")
-	must(loginCmd.MarkFlagRequired("username"))
-	loginCmd.Flags().BoolVarP(&save, "save", "s", false, "Save the token to the config file")
+	loginCmd.Flags().StringVarP(&username, "username", "u", "", "Twitch username, taken from the token if not set")
+	loginCmd.Flags().BoolVarP(&save, "save", "s", false, "Save the token to the token store if it can be unlocked, otherwise to the secrets file")
 }
 
 var loginCmd = &cobra.Command{
@@ -25,34 +27,60 @@ var loginCmd = &cobra.Command{
 	Long:  "Login to Twitch. This will start a OAuth2 flow to get a token.",
 	Run: func(cmd *cobra.Command, args []string) {
 		session := miner.NewLoginSession()
+		session.Endpoints = loadEndpoints()
 		code, err := session.GetCode()
 		cobra.CheckErr(err)
 
 		cmd.Println("Please open the following URL in your browser:")
-		cmd.Println("  https://www.twitch.tv/activate")
+		cmd.Println("  " + session.Endpoints.Website + "/activate")
 		cmd.Println("And enter the following code:")
 		cmd.Println("  " + code)
 		cmd.Println("Waiting for authentication...")
 		token, err := session.WaitForToken()
 		cobra.CheckErr(err)
 
+		info, err := miner.ValidateToken(session.Endpoints, token)
+		cobra.CheckErr(err)
+		if username != "" && !strings.EqualFold(username, info.Login) {
+			cmd.PrintErrln("Logged in as", info.Login, "instead of", username)
+		}
+		username = info.Login
+
+		if save {
+			path, err := saveLogin(username, token)
+			cobra.CheckErr(err)
+			cmd.Println("Token saved to", path)
+			return
+		}
+
 		cmd.Println("Token:", token)
 		cmd.Println("Add it to your config file:")
 		cmd.Println("")
 		cmd.Println("users:")
 		cmd.Println("  - name: " + username)
 		cmd.Println("    token: " + token)
+		cmd.Println("")
+		cmd.Println("Or run the login command with --save to keep it in a separate secrets file.")
+	},
+}
 
-		if save {
-			users := viper.Get("users").([]any)
-			users = append(users, map[string]string{
-				"name":  username,
-				"token": token,
-			})
-
-			viper.Set("users", users)
-			cobra.CheckErr(viper.WriteConfig())
-			cmd.Println("Config file updated.")
+// saveLogin saves the token to the token store if a key is given, otherwise to the plaintext secrets file.
+// Returns where the token was saved.
+func saveLogin(name string, token string) (string, error) {
+	key, err := storeKey()
+	if errors.Is(err, errNoKey) {
+		if storeExists() {
+			return "", fmt.Errorf("%s exists but can not be unlocked: %w", storePath(), err)
 		}
-	},
+		return secretsPath(), saveToken(name, token)
+	} else if err != nil {
+		return "", err
+	}
+
+	tokens, err := loadStore()
+	if err != nil {
+		return "", err
+	}
+	tokens[name] = token
+	return storePath(), saveStore(tokens, key)
 }
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/spf13/viper"
+)
+
+type userConfig struct {
+	Name string `mapstructure:"name"`
+	// Token is resolved by loadUsers, see resolveToken
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+
+	overridesConfig `mapstructure:",squash"`
+	Points          struct {
+		ConcurrentPointLimit *int    `mapstructure:"concurrent_point_limit"`
+		ConcurrentWatchLimit *int    `mapstructure:"concurrent_watch_limit"`
+		PrioritizeStreaks    *bool   `mapstructure:"prioritize_streaks"`
+		Strategy             *string `mapstructure:"strategy"`
+	} `mapstructure:"points"`
+	Follows   *bool          `mapstructure:"follows"`
+	Streamers map[string]int `mapstructure:"streamers"`
+	Exclude   []string       `mapstructure:"exclude"`
+}
+
+// is returns whether the user was added for this config, users without a name are recognized by their token
+func (c userConfig) is(user *miner.User) bool {
+	if c.Name == "" {
+		return c.Token == user.AuthToken
+	}
+	return c.Name == user.Username
+}
+
+func (c userConfig) options() miner.UserOptions {
+	return miner.UserOptions{
+		OptionOverrides:      c.overrides(),
+		PrioritizeStreaks:    c.Points.PrioritizeStreaks,
+		ConcurrentPointLimit: c.Points.ConcurrentPointLimit,
+		ConcurrentWatchLimit: c.Points.ConcurrentWatchLimit,
+		MiningStrategy:       c.Points.Strategy,
+		FollowStreamers:      c.Follows,
+		Streamers:            c.Streamers,
+		ExcludeStreamers:     c.Exclude,
+	}
+}
+
+// loadUsers returns the users of the config file with their tokens, followed by the users only found in the secrets file or token store
+func loadUsers() ([]userConfig, error) {
+	users := []userConfig{}
+	if err := viper.UnmarshalKey("users", &users); err != nil {
+		return nil, fmt.Errorf("invalid users: %w", err)
+	}
+	tokens, err := loadTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range users {
+		// without a name, the user is whoever the token belongs to
+		if user.Name == "" {
+			if user.Token == "" && user.TokenFile == "" {
+				return nil, fmt.Errorf("user %d needs a name or a token", i)
+			} else if !reflect.DeepEqual(user.options(), miner.UserOptions{}) {
+				return nil, fmt.Errorf("user %d needs a name for its options", i)
+			}
+		}
+		name := cmp.Or(user.Name, fmt.Sprint(i))
+		token, err := user.resolveToken(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("token of user %s: %w", name, err)
+		} else if token == "" {
+			return nil, fmt.Errorf("user %s has no token, set token or token_file or run the login command with --save", name)
+		}
+		users[i].Token = token
+	}
+	for _, name := range slices.Sorted(maps.Keys(tokens)) {
+		if !slices.ContainsFunc(users, func(user userConfig) bool { return user.Name == name || user.Token == tokens[name] }) {
+			users = append(users, userConfig{Name: name, Token: tokens[name]})
+		}
+	}
+	return users, nil
+}
+
+// loadUserOptions returns the options of the users that change any
+func loadUserOptions(users []userConfig) map[string]miner.UserOptions {
+	options := map[string]miner.UserOptions{}
+	for _, user := range users {
+		if userOptions := user.options(); !reflect.DeepEqual(userOptions, miner.UserOptions{}) {
+			options[user.Name] = userOptions
+		}
+	}
+	return options
+}
+
+// reloadDelay is how long the files have to stay unchanged before they are reloaded.
+// Editors and login --save often write a file in several steps, which would otherwise be reloaded half written.
+const reloadDelay = 500 * time.Millisecond
+
+// watchConfig applies changes of the config file, the secrets file and the token store to the running miner.
+// Changes of the tokens resume paused users after login --save.
+func watchConfig(instance *miner.Miner) {
+	if viper.ConfigFileUsed() == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Println("Failed to watch the config file:", err)
+		return
+	}
+	files := []string{filepath.Clean(viper.ConfigFileUsed()), filepath.Clean(secretsPath()), filepath.Clean(storePath())}
+	// the directories are watched, editors and the token store replace files instead of writing to them
+	dirs := []string{}
+	for _, file := range files {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	slices.Sort(dirs)
+	for _, dir := range slices.Compact(dirs) {
+		if err := watcher.Add(dir); err != nil {
+			fmt.Println("Failed to watch", dir, ":", err)
+		}
+	}
+
+	var timer *time.Timer
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !slices.Contains(files, filepath.Clean(event.Name)) || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(reloadDelay, func() { reloadConfig(instance) })
+				} else {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Println("Error watching the config file:", err)
+			}
+		}
+	}()
+}
+
+// loadedConfig is the content of the config file viper was loaded with, it is restored when a reload fails
+var loadedConfig []byte
+
+// reloadLock serializes reloads and guards loadedConfig
+var reloadLock sync.Mutex
+
+// reloadConfig applies the options, users and streamers of the config file.
+// Invalid configs are logged and the miner keeps running with the old one.
+func reloadConfig(instance *miner.Miner) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	path := viper.ConfigFileUsed()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Keeping the old config, failed to read", path, ":", err)
+		return
+	}
+	// an editor truncating the file before writing it would otherwise remove every user
+	if len(bytes.TrimSpace(content)) == 0 {
+		fmt.Println("Keeping the old config,", path, "is empty")
+		return
+	}
+	if err := checkConfigFile(path); err != nil {
+		fmt.Println("Keeping the old config, the new one is invalid:")
+		fmt.Println(err)
+		return
+	}
+
+	// everything below reads the new config from viper, so the old one is restored if it can not be applied
+	keep := func(reason ...any) {
+		fmt.Println(append([]any{"Keeping the old config:"}, reason...)...)
+		if err := viper.ReadConfig(bytes.NewReader(loadedConfig)); err != nil {
+			fmt.Println("Failed to restore the old config:", err)
+		}
+	}
+	if err := viper.ReadConfig(bytes.NewReader(content)); err != nil {
+		keep("failed to parse", path, ":", err)
+		return
+	}
+	users, err := loadUsers()
+	if err != nil {
+		keep(err)
+		return
+	}
+	if len(users) == 0 {
+		keep("it has no users")
+		return
+	}
+
+	old := instance.GetOptions()
+	options := loadOptions(users)
+	if err := instance.UpdateOptions(options); err != nil {
+		keep(err)
+		return
+	}
+	loadedConfig = content
+
+	for _, user := range instance.GetUsers() {
+		i := slices.IndexFunc(users, func(config userConfig) bool { return config.is(user) })
+		if i >= 0 && users[i].Token == user.AuthToken {
+			continue
+		}
+		fmt.Println("Removing user", user.Username)
+		if err := instance.RemoveUser(user.Username); err != nil {
+			fmt.Println("Error removing user", user.Username, ":", err)
+		}
+	}
+	added := []*miner.User{}
+	for _, config := range users {
+		if slices.ContainsFunc(instance.GetUsers(), config.is) {
+			continue
+		}
+		fmt.Println("Adding user", cmp.Or(config.Name, "without name"))
+		user := miner.NewUser(config.Name, config.Token)
+		if err := addUser(instance, user, options); err != nil {
+			fmt.Println("Error adding user:", err)
+			continue
+		}
+		added = append(added, user)
+	}
+
+	for _, user := range instance.GetUsers() {
+		// added users already got their streamers, paused users get them once they are added again with a new token
+		if slices.Contains(added, user) || user.AuthError() != nil {
+			continue
+		}
+		updateStreamers(instance, user, old.ForUser(user.Username), options.ForUser(user.Username))
+	}
+}
+
+// updateStreamers adds and removes the streamers of the user that changed between the old and the new options of the user
+func updateStreamers(instance *miner.Miner, user *miner.User, old miner.Options, options miner.Options) {
+	oldPinned := old.PinnedStreamers()
+	pinned := options.PinnedStreamers()
+	added := slices.DeleteFunc(slices.Clone(pinned), func(streamer string) bool { return slices.Contains(oldPinned, streamer) })
+	removed := slices.DeleteFunc(oldPinned, func(streamer string) bool { return slices.Contains(pinned, streamer) })
+
+	if err := instance.BulkAddStreamers(user, added); err != nil {
+		fmt.Println("Error adding streamers for user", user.Username, ":", err)
+	}
+
+	// without follows only the pinned streamers are mined
+	if !options.FollowStreamers {
+		for _, streamer := range user.GetStreamers() {
+			if options.Pinned(streamer.Username) {
+				continue
+			}
+			if err := instance.RemoveStreamer(user, streamer.Username); err != nil {
+				fmt.Println("Error removing streamer", streamer.Username, "for user", user.Username, ":", err)
+			}
+		}
+		return
+	}
+	// unpinned streamers the user follows are still mined, excluded ones are removed and streamers that are not excluded anymore are added
+	if len(removed) > 0 || !old.FollowStreamers || !slices.Equal(old.ExcludeStreamers, options.ExcludeStreamers) {
+		if err := instance.SyncFollows(user); err != nil {
+			fmt.Println("Error syncing follows for user", user.Username, ":", err)
+		}
+	}
+}
+
+// addUser adds the user with its follows and the pinned streamers
+func addUser(instance *miner.Miner, user *miner.User, options miner.Options) error {
+	if err := instance.AddUser(user); err != nil {
+		return err
+	}
+	options = options.ForUser(user.Username)
+	pinned := options.PinnedStreamers()
+	if options.FollowStreamers {
+		if err := instance.AddStreamersFromFollows(user); err != nil {
+			fmt.Println("Error adding streamers from follows for user", user.Username, ":", err)
+		}
+	}
+	if len(pinned) > 0 {
+		if err := instance.BulkAddStreamers(user, pinned); err != nil {
+			fmt.Println("Error adding streamers for user", user.Username, ":", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replaySpeed      float64
+	replayPoints     int
+	replayWait       time.Duration
+	replayPersistent string
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "s", 1, "Playback speed, 1 keeps the original timing and 0 replays without any delay")
+	replayCmd.Flags().IntVar(&replayPoints, "points", 10_000, "Channel points balance of every user on every streamer")
+	replayCmd.Flags().DurationVar(&replayWait, "wait", 10*time.Second, "How long to wait for pending bets after the last message")
+	replayCmd.Flags().StringVar(&replayPersistent, "persistent", "", "Persistent state to load, for example a copy of persistent.json (it will be overwritten)")
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Replay recorded PubSub traffic",
+	Long: "Feed a PubSub recording (see debug.record_pubsub) through the miner. " +
+		"All requests the miner makes in response (claims, bets, raids) go to a local fake Twitch, so nothing is sent to real accounts.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		recording, err := miner.ReadRecording(args[0])
+		cobra.CheckErr(err)
+
+		script := &simulator.Script{}
+		for _, message := range recording {
+			if message.Accounts == nil {
+				continue
+			}
+			for _, user := range message.Accounts.Users {
+				script.Users = append(script.Users, simulator.ScriptUser{Name: user.Name, ID: user.ID})
+			}
+			for _, streamer := range message.Accounts.Streamers {
+				script.Channels = append(script.Channels, simulator.ScriptChannel{Name: streamer.Name, ID: streamer.ID, Points: replayPoints, Live: true})
+			}
+			// later snapshots only repeat the accounts, the first one is enough
+			break
+		}
+		if len(script.Users) == 0 {
+			cobra.CheckErr(fmt.Errorf("recording contains no accounts"))
+		}
+		script, err = simulator.NewScript(script)
+		cobra.CheckErr(err)
+
+		server := simulator.NewServer(script)
+		cobra.CheckErr(server.Start("127.0.0.1:0", "127.0.0.1:0"))
+		defer func() {
+			_ = server.Close()
+		}()
+
+		// the users come from the script, the config users only provide their options
+		users, err := loadUsers()
+		cobra.CheckErr(err)
+		options := loadOptions(users)
+		options.Endpoints = server.Endpoints()
+		options.PersistentFile = replayPersistent
+		options.RecordFile = ""
+		options.PrometheusEnabled = false
+
+		instance := miner.NewMiner(options)
+		for _, scriptUser := range script.Users {
+			user := miner.NewUser(scriptUser.Name, scriptUser.Token)
+			user.ID = scriptUser.ID
+			cobra.CheckErr(instance.AddUser(user))
+		}
+		for _, user := range instance.GetUsers() {
+			streamers := []string{}
+			for _, channel := range script.Channels {
+				streamers = append(streamers, channel.Name)
+			}
+			if err := instance.BulkAddStreamers(user, streamers); err != nil {
+				cmd.PrintErrln("Error adding streamers:", err)
+			}
+		}
+		cobra.CheckErr(instance.UpdateVersions())
+
+		var previous time.Time
+		replayed := 0
+		for _, recorded := range recording {
+			if recorded.Message == nil || recorded.Message.Type != "MESSAGE" || recorded.Message.Data == nil {
+				continue
+			}
+			if !previous.IsZero() && replaySpeed > 0 {
+				time.Sleep(time.Duration(float64(recorded.Time.Sub(previous)) / replaySpeed))
+			}
+			previous = recorded.Time
+
+			message, err := recorded.Message.Parse()
+			if err != nil {
+				cmd.PrintErrln("Skipping invalid message:", err)
+				continue
+			}
+			cmd.Println("Replaying", recorded.Time.Format(time.RFC3339), recorded.Message.Data.Topic, message.Type)
+			instance.OnMessage(message)
+			replayed++
+		}
+
+		cmd.Println("Replayed", replayed, "messages, waiting", replayWait, "for pending actions")
+		time.Sleep(replayWait)
+		cmd.Println("Calls:", server.Calls())
+	},
+}
@@ -25,6 +25,9 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./tcpm.yaml)")
+	rootCmd.PersistentFlags().StringVar(&secretsFile, "secrets", "", "secrets file with the tokens (default is secrets.yaml next to the config file)")
+	rootCmd.PersistentFlags().StringVar(&storeFile, "token-store", "", "encrypted token store (default is tokens.enc next to the config file)")
+	rootCmd.PersistentFlags().StringVar(&keyFile, "key-file", "", "file with the key of the token store, instead of "+passphraseEnv)
 }
 
 func initConfig() {
@@ -36,36 +39,82 @@ func initConfig() {
 		viper.AddConfigPath(".")
 	}
 
+	defaults := miner.DefaultOptions()
 	viper.SetDefault("users", []map[string]string{})
-	viper.SetDefault("mine.points", true)
-	viper.SetDefault("mine.raids", true)
-	viper.SetDefault("mine.moments", true)
-	viper.SetDefault("mine.watchtime", true)
-	viper.SetDefault("mine.predictions", true)
-	viper.SetDefault("predictions.min_points", 1_000)
-	viper.SetDefault("predictions.max_bet", 50_000)
-	viper.SetDefault("predictions.max_ratio", 2)
-	viper.SetDefault("predictions.stealth", false)
-	viper.SetDefault("predictions.strategy", miner.PredictionStrategyCautious)
-	viper.SetDefault("predictions.min_data_points", 5)
-	viper.SetDefault("points.concurrent_point_limit", 2)
-	viper.SetDefault("points.concurrent_watch_limit", 0)
-	viper.SetDefault("points.prioritize_streaks", true)
-	viper.SetDefault("points.strategy", miner.MiningStrategyLeastPoints)
-	viper.SetDefault("chat.only_live", true)
-	viper.SetDefault("chat.follow_chat_spam", false)
-	viper.SetDefault("streamers.follows", true)
+	viper.SetDefault("mine.points", defaults.MinePoints)
+	viper.SetDefault("mine.raids", defaults.MineRaids)
+	viper.SetDefault("mine.moments", defaults.MineMoments)
+	viper.SetDefault("mine.watchtime", defaults.MineWatchtime)
+	viper.SetDefault("mine.predictions", defaults.MinePredictions)
+	viper.SetDefault("predictions.min_points", defaults.PredictionsMinPoints)
+	viper.SetDefault("predictions.max_bet", defaults.PredictionsMaxBet)
+	viper.SetDefault("predictions.max_ratio", defaults.PredictionsMaxRatio)
+	viper.SetDefault("predictions.stealth", defaults.PredictionsStealth)
+	viper.SetDefault("predictions.strategy", defaults.PredictionsStrategy)
+	viper.SetDefault("predictions.min_data_points", defaults.PredictionsDataPoints)
+	viper.SetDefault("points.concurrent_point_limit", defaults.ConcurrentPointLimit)
+	viper.SetDefault("points.concurrent_watch_limit", defaults.ConcurrentWatchLimit)
+	viper.SetDefault("points.prioritize_streaks", defaults.PrioritizeStreaks)
+	viper.SetDefault("points.strategy", defaults.MiningStrategy)
+	viper.SetDefault("chat.only_live", defaults.WatchTimeOnlyLive)
+	viper.SetDefault("chat.follow_chat_spam", defaults.FollowChatSpam)
+	viper.SetDefault("streamers.follows", defaults.FollowStreamers)
 	viper.SetDefault("streamers.streamers", map[string]int{})
-	viper.SetDefault("persistent.file", "persistent.json")
-	viper.SetDefault("prometheus.enabled", false)
-	viper.SetDefault("prometheus.port", 8080)
-	viper.SetDefault("prometheus.host", "localhost")
+	viper.SetDefault("persistent.file", defaults.PersistentFile)
+	viper.SetDefault("prometheus.enabled", defaults.PrometheusEnabled)
+	viper.SetDefault("prometheus.port", defaults.PrometheusPort)
+	viper.SetDefault("prometheus.host", defaults.PrometheusHost)
+	viper.SetDefault("pubsub.connections_per_minute", defaults.PubSubConnectionsPerMinute)
+	viper.SetDefault("pubsub.transport", defaults.Transport)
+
+	for name, schedule := range defaults.Schedule {
+		viper.SetDefault("schedule."+name+".interval", schedule.Interval)
+		viper.SetDefault("schedule."+name+".jitter", schedule.Jitter)
+	}
+
+	endpoints := defaults.Endpoints
+	viper.SetDefault("endpoints.website", endpoints.Website)
+	viper.SetDefault("endpoints.gql", endpoints.GraphQL)
+	viper.SetDefault("endpoints.usher", endpoints.Usher)
+	viper.SetDefault("endpoints.id", endpoints.ID)
+	viper.SetDefault("endpoints.pubsub", endpoints.PubSub)
+	viper.SetDefault("endpoints.eventsub", endpoints.EventSub)
+	viper.SetDefault("endpoints.helix", endpoints.Helix)
+	viper.SetDefault("endpoints.irc", endpoints.IRC)
+	viper.SetDefault("endpoints.irc_tls", endpoints.IRCTLS)
 
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		// a failed reload restores this content
+		loadedConfig, _ = os.ReadFile(viper.ConfigFileUsed())
+	}
+}
+
+func loadEndpoints() miner.Endpoints {
+	return miner.Endpoints{
+		Website:  viper.GetString("endpoints.website"),
+		GraphQL:  viper.GetString("endpoints.gql"),
+		Usher:    viper.GetString("endpoints.usher"),
+		ID:       viper.GetString("endpoints.id"),
+		PubSub:   viper.GetString("endpoints.pubsub"),
+		EventSub: viper.GetString("endpoints.eventsub"),
+		Helix:    viper.GetString("endpoints.helix"),
+		IRC:      viper.GetString("endpoints.irc"),
+		IRCTLS:   viper.GetBool("endpoints.irc_tls"),
+	}
+}
+
+func loadSchedule() map[string]miner.TaskSchedule {
+	schedule := map[string]miner.TaskSchedule{}
+	for name := range miner.DefaultSchedule() {
+		schedule[name] = miner.TaskSchedule{
+			Interval: viper.GetDuration("schedule." + name + ".interval"),
+			Jitter:   viper.GetDuration("schedule." + name + ".jitter"),
+		}
 	}
+	return schedule
 }
 
 func Execute() {
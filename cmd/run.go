@@ -1,7 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
 	"github.com/spf13/cobra"
@@ -19,8 +27,21 @@ var (
 		Long:  "Run the Twitch Channel Point Miner with the specified configuration.",
 
 		Run: func(cmd *cobra.Command, args []string) {
-			usersObjs := viper.Get("users").([]any)
-			if len(usersObjs) == 0 {
+			if path := viper.ConfigFileUsed(); path != "" {
+				if err := checkConfigFile(path); err != nil {
+					cmd.PrintErrln("Invalid configuration:")
+					cmd.PrintErrln(err)
+					os.Exit(1)
+					return
+				}
+			}
+			users, err := loadUsers()
+			if err != nil {
+				cmd.PrintErrln("Invalid configuration:", err)
+				os.Exit(1)
+				return
+			}
+			if len(users) == 0 {
 				cmd.PrintErrln("No users found in the configuration file.")
 				if !autoLogin {
 					cmd.PrintErrln("Please run the login command to add users.")
@@ -29,64 +50,235 @@ var (
 				}
 				save = true
 				must(loginCmd.Execute())
+				users, err = loadUsers()
+				cobra.CheckErr(err)
 			}
 
-			options := miner.Options{
-				MinePoints:            viper.GetBool("mine.points"),
-				PrioritizeStreaks:     viper.GetBool("points.prioritize_streaks"),
-				ConcurrentPointLimit:  viper.GetInt("points.concurrent_point_limit"),
-				ConcurrentWatchLimit:  viper.GetInt("points.concurrent_watch_limit"),
-				MiningStrategy:        miner.MiningStrategy(viper.GetString("points.strategy")),
-				MineRaids:             viper.GetBool("mine.raids"),
-				MineMoments:           viper.GetBool("mine.moments"),
-				MinePredictions:       viper.GetBool("mine.predictions"),
-				PredictionsMinPoints:  viper.GetInt("predictions.min_points"),
-				PredictionsMaxBet:     viper.GetInt("predictions.max_bet"),
-				PredictionsMaxRatio:   viper.GetInt("predictions.max_ratio"),
-				PredictionsStealth:    viper.GetBool("predictions.stealth"),
-				PredictionsStrategy:   miner.PredictionStrategy(viper.GetString("predictions.strategy")),
-				PredictionsDataPoints: viper.GetInt("predictions.min_data_points"),
-				MineWatchtime:         viper.GetBool("mine.watchtime"),
-				WatchTimeOnlyLive:     viper.GetBool("chat.only_live"),
-				FollowChatSpam:        viper.GetBool("chat.follow_chat_spam"),
-				StreamerPriority:      map[string]int{},
-				DebugWebhook:          viper.GetString("debug.webhook"),
-				PersistentFile:        viper.GetString("persistent.file"),
-				PrometheusEnabled:     viper.GetBool("prometheus.enabled"),
-				PrometheusPort:        viper.GetInt("prometheus.port"),
-				PrometheusHost:        viper.GetString("prometheus.host"),
+			options := loadOptions(users)
+			instance, err := miner.New(miner.WithOptions(options))
+			if err != nil {
+				cmd.PrintErrln("Invalid configuration:", err)
+				os.Exit(1)
+				return
 			}
-			addFollowers := viper.GetBool("streamers.follows")
-			instance := miner.NewMiner(options)
-			users := []*miner.User{}
-			for _, user := range usersObjs {
-				userObj := user.(map[string]any)
-				user := miner.NewUser(userObj["name"].(string), userObj["token"].(string))
-				users = append(users, user)
-				instance.AddUser(user)
-				if addFollowers {
-					if err := instance.AddStreamersFromFollows(user); err != nil {
-						cmd.PrintErrln("Error adding streamers from follows for user", user.Username, ":", err)
-					}
+			// every user is tried, so all broken tokens are reported at once
+			errs := []error{}
+			for _, config := range users {
+				if err := addUser(instance, miner.NewUser(config.Name, config.Token), options); err != nil {
+					errs = append(errs, err)
 				}
 			}
-			priorities, ok := viper.Get("streamers.streamers").(map[string]any)
-			if ok {
-				streamers := make([]string, 0, len(priorities))
-				for k := range priorities {
-					streamers = append(streamers, k)
-					options.StreamerPriority[k] = priorities[k].(int)
-				}
-				for _, user := range users {
-					instance.BulkAddStreamers(user, streamers)
-				}
+			if err := errors.Join(errs...); err != nil {
+				cmd.PrintErrln("Failed to add users, log in again or remove them from the configuration:")
+				cmd.PrintErrln(err)
+				os.Exit(1)
+				return
+			}
+			watchConfig(instance)
+			// SIGTERM is what docker sends on stop
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if err := instance.Run(ctx); err != nil {
+				cmd.PrintErrln("Error:", err)
+				os.Exit(1)
 			}
-			err := instance.Run()
-			cobra.CheckErr(err)
 		},
 	}
 )
 
+func loadOptions(users []userConfig) miner.Options {
+	return miner.Options{
+		MinePoints:                 viper.GetBool("mine.points"),
+		PrioritizeStreaks:          viper.GetBool("points.prioritize_streaks"),
+		ConcurrentPointLimit:       viper.GetInt("points.concurrent_point_limit"),
+		ConcurrentWatchLimit:       viper.GetInt("points.concurrent_watch_limit"),
+		MiningStrategy:             miner.MiningStrategy(viper.GetString("points.strategy")),
+		MineRaids:                  viper.GetBool("mine.raids"),
+		MineMoments:                viper.GetBool("mine.moments"),
+		MinePredictions:            viper.GetBool("mine.predictions"),
+		PredictionsMinPoints:       viper.GetInt("predictions.min_points"),
+		PredictionsMaxBet:          viper.GetInt("predictions.max_bet"),
+		PredictionsMaxRatio:        viper.GetInt("predictions.max_ratio"),
+		PredictionsStealth:         viper.GetBool("predictions.stealth"),
+		PredictionsStrategy:        miner.PredictionStrategy(viper.GetString("predictions.strategy")),
+		PredictionsDataPoints:      viper.GetInt("predictions.min_data_points"),
+		MineWatchtime:              viper.GetBool("mine.watchtime"),
+		WatchTimeOnlyLive:          viper.GetBool("chat.only_live"),
+		FollowChatSpam:             viper.GetBool("chat.follow_chat_spam"),
+		StreamerPriority:           loadStreamerPriority(),
+		StreamerOverrides:          loadStreamerOverrides(),
+		StreamerGroups:             loadStreamerGroups(),
+		UserOptions:                loadUserOptions(users),
+		FollowStreamers:            viper.GetBool("streamers.follows"),
+		DebugWebhook:               loadWebhook(),
+		RecordFile:                 viper.GetString("debug.record_pubsub"),
+		PersistentFile:             viper.GetString("persistent.file"),
+		PrometheusEnabled:          viper.GetBool("prometheus.enabled"),
+		PrometheusPort:             viper.GetInt("prometheus.port"),
+		PrometheusHost:             viper.GetString("prometheus.host"),
+		Endpoints:                  loadEndpoints(),
+		PubSubConnectionsPerMinute: viper.GetInt("pubsub.connections_per_minute"),
+		Transport:                  miner.TransportType(viper.GetString("pubsub.transport")),
+		Schedule:                   loadSchedule(),
+	}
+}
+
+// loadStreamerPriority returns the streamers of streamers.streamers with their priority
+func loadStreamerPriority() map[string]int {
+	priority := map[string]int{}
+	streamers, _ := viper.Get("streamers.streamers").(map[string]any)
+	for streamer, value := range streamers {
+		switch value := value.(type) {
+		case int:
+			priority[streamer] = value
+		default:
+			priority[streamer] = viper.GetInt("streamers.streamers." + streamer + ".priority")
+		}
+	}
+	return priority
+}
+
+// overridesConfig mirrors the global keys that can be overridden, unset keys stay nil
+type overridesConfig struct {
+	Mine struct {
+		Points      *bool `mapstructure:"points"`
+		Raids       *bool `mapstructure:"raids"`
+		Predictions *bool `mapstructure:"predictions"`
+		Watchtime   *bool `mapstructure:"watchtime"`
+	} `mapstructure:"mine"`
+	Chat struct {
+		FollowChatSpam *bool `mapstructure:"follow_chat_spam"`
+	} `mapstructure:"chat"`
+	Predictions struct {
+		MaxBet        *int    `mapstructure:"max_bet"`
+		MaxRatio      *int    `mapstructure:"max_ratio"`
+		MinPoints     *int    `mapstructure:"min_points"`
+		Stealth       *bool   `mapstructure:"stealth"`
+		Strategy      *string `mapstructure:"strategy"`
+		MinDataPoints *int    `mapstructure:"min_data_points"`
+	} `mapstructure:"predictions"`
+}
+
+func (c overridesConfig) overrides() miner.OptionOverrides {
+	return miner.OptionOverrides{
+		MinePoints:            c.Mine.Points,
+		MineRaids:             c.Mine.Raids,
+		MinePredictions:       c.Mine.Predictions,
+		MineWatchtime:         c.Mine.Watchtime,
+		FollowChatSpam:        c.Chat.FollowChatSpam,
+		PredictionsDataPoints: c.Predictions.MinDataPoints,
+		PredictionsMinPoints:  c.Predictions.MinPoints,
+		PredictionsMaxBet:     c.Predictions.MaxBet,
+		PredictionsMaxRatio:   c.Predictions.MaxRatio,
+		PredictionsStealth:    c.Predictions.Stealth,
+		PredictionsStrategy:   c.Predictions.Strategy,
+	}
+}
+
+// loadStreamerOverrides returns the options of the streamers in streamers.streamers that are objects instead of a priority
+func loadStreamerOverrides() map[string]miner.OptionOverrides {
+	overrides := map[string]miner.OptionOverrides{}
+	streamers, _ := viper.Get("streamers.streamers").(map[string]any)
+	for streamer, value := range streamers {
+		if _, ok := value.(map[string]any); !ok {
+			continue
+		}
+		var config overridesConfig
+		if err := viper.UnmarshalKey("streamers.streamers."+streamer, &config); err != nil {
+			fmt.Println("Invalid options for streamer", streamer, ":", err)
+			continue
+		}
+		overrides[streamer] = config.overrides()
+	}
+	return overrides
+}
+
+// loadWebhook returns debug.webhook with ${VAR} references expanded, the webhook url is a secret as well
+func loadWebhook() string {
+	webhook, err := expandEnv(viper.GetString("debug.webhook"))
+	if err != nil {
+		fmt.Println("Invalid debug.webhook:", err)
+	}
+	return webhook
+}
+
+type groupConfig struct {
+	Name      string   `mapstructure:"name"`
+	Streamers []string `mapstructure:"streamers"`
+	Priority  *int     `mapstructure:"priority"`
+	Schedule  []struct {
+		Days []string `mapstructure:"days"`
+		From string   `mapstructure:"from"`
+		To   string   `mapstructure:"to"`
+	} `mapstructure:"schedule"`
+
+	overridesConfig `mapstructure:",squash"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseTimeOfDay parses times like 18:30 into the offset from midnight, an empty string is midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || !ok {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// loadStreamerGroups returns the groups of streamers.groups, groups with an invalid schedule are skipped
+func loadStreamerGroups() []miner.StreamerGroup {
+	configs := []groupConfig{}
+	if err := viper.UnmarshalKey("streamers.groups", &configs); err != nil {
+		fmt.Println("Invalid streamer groups:", err)
+		return nil
+	}
+	groups := []miner.StreamerGroup{}
+	for _, config := range configs {
+		group := miner.StreamerGroup{
+			Name:            config.Name,
+			Streamers:       config.Streamers,
+			Priority:        config.Priority,
+			OptionOverrides: config.overrides(),
+		}
+		errs := []error{}
+		for _, window := range config.Schedule {
+			from, err := parseTimeOfDay(window.From)
+			errs = append(errs, err)
+			to, err := parseTimeOfDay(window.To)
+			errs = append(errs, err)
+			days := []time.Weekday{}
+			for _, day := range window.Days {
+				weekday, ok := weekdays[strings.ToLower(day)]
+				if !ok {
+					errs = append(errs, fmt.Errorf("invalid day %q", day))
+				}
+				days = append(days, weekday)
+			}
+			group.Schedule = append(group.Schedule, miner.TimeWindow{Days: days, From: from, To: to})
+		}
+		if err := errors.Join(errs...); err != nil {
+			fmt.Println("Skipping streamer group", config.Name, ":", err)
+			continue
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
 func init() {
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().BoolVarP(&autoLogin, "login", "l", false, "Automatically login if no users are found")
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// configSchemaJSON is the JSON Schema of tcpm.yaml, editors can use it for completion
+//
+//go:embed config.schema.json
+var configSchemaJSON []byte
+
+// schema is the subset of JSON Schema used by config.schema.json
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*schema `json:"$defs"`
+	Description          string             `json:"description"`
+	AnyOf                []*schema          `json:"anyOf"`
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Pattern              string             `json:"pattern"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is the type keyword, which is either a single type or a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// additional is the additionalProperties keyword, which is either a boolean or a schema
+type additional struct {
+	forbidden bool
+	schema    *schema
+}
+
+func (a *additional) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		a.forbidden = !allowed
+		return nil
+	}
+	return json.Unmarshal(data, &a.schema)
+}
+
+// configError is a problem with a value of the config file
+type configError struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e configError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+func loadConfigSchema() (*schema, error) {
+	var root schema
+	if err := json.Unmarshal(configSchemaJSON, &root); err != nil {
+		return nil, fmt.Errorf("invalid config schema: %w", err)
+	}
+	return &root, nil
+}
+
+// validateConfigFile checks the config file against the schema.
+// The returned error is only set if the file can not be read or parsed at all.
+func validateConfigFile(path string) ([]configError, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	root, err := loadConfigSchema()
+	if err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		// empty file, everything is default
+		return nil, nil
+	}
+
+	validator := &schemaValidator{root: root}
+	validator.validate(root, document.Content[0], "")
+	return validator.errors, nil
+}
+
+type schemaValidator struct {
+	root   *schema
+	errors []configError
+}
+
+func (v *schemaValidator) fail(node *yaml.Node, path string, format string, args ...any) {
+	v.errors = append(v.errors, configError{node.Line, node.Column, path, fmt.Sprintf(format, args...)})
+}
+
+// resolve follows $ref, only references into $defs of the root schema are supported
+func (v *schemaValidator) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		def := v.root.Defs[name]
+		if !ok || def == nil {
+			panic("unsupported schema reference " + s.Ref)
+		}
+		s = def
+	}
+	return s
+}
+
+func (v *schemaValidator) validate(s *schema, node *yaml.Node, path string) {
+	s = v.resolve(s)
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched, closest := v.anyOf(s.AnyOf, node, path)
+		if matched {
+			return
+		}
+		if closest != nil && (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) {
+			// the problem is somewhere inside, which is more helpful than rejecting the whole value
+			v.errors = append(v.errors, closest...)
+		} else if s.Description != "" {
+			v.fail(node, path, "invalid value %q, expected %s", node.Value, s.Description)
+		} else {
+			v.fail(node, path, "invalid value %q", node.Value)
+		}
+		return
+	}
+
+	kind := nodeType(node)
+	if len(s.Type) > 0 && !slices.Contains(s.Type, kind) && !(kind == "integer" && slices.Contains(s.Type, "number")) {
+		v.fail(node, path, "must be %s, got %s", strings.Join(s.Type, " or "), kind)
+		return
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(value any) bool { return fmt.Sprint(value) == node.Value }) {
+		options := []string{}
+		for _, value := range s.Enum {
+			options = append(options, fmt.Sprint(value))
+		}
+		v.fail(node, path, "must be one of %s, got %q", strings.Join(options, ", "), node.Value)
+		return
+	}
+
+	switch kind {
+	case "string":
+		if s.Pattern == "" {
+			break
+		}
+		if s.pattern == nil {
+			s.pattern = regexp.MustCompile(s.Pattern)
+		}
+		if !s.pattern.MatchString(node.Value) && s.Description != "" {
+			v.fail(node, path, "invalid value %q, expected %s", node.Value, s.Description)
+		} else if !s.pattern.MatchString(node.Value) {
+			v.fail(node, path, "invalid value %q", node.Value)
+		}
+	case "integer", "number":
+		var number float64
+		if err := node.Decode(&number); err != nil {
+			v.fail(node, path, "%s", err)
+			return
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			v.fail(node, path, "must be at least %v, got %v", *s.Minimum, number)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			v.fail(node, path, "must be at most %v, got %v", *s.Maximum, number)
+		}
+	case "array":
+		if s.Items == nil {
+			break
+		}
+		for i, item := range node.Content {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "object":
+		v.validateObject(s, node, path)
+	}
+}
+
+// anyOf returns whether the node is valid for at least one of the schemas.
+// Otherwise closest are the errors of the first schema that accepts the type of the node
+func (v *schemaValidator) anyOf(schemas []*schema, node *yaml.Node, path string) (matched bool, closest []configError) {
+	for _, s := range schemas {
+		alternative := &schemaValidator{root: v.root}
+		alternative.validate(s, node, path)
+		if len(alternative.errors) == 0 {
+			return true, nil
+		}
+		if closest == nil && slices.Contains(v.resolve(s).Type, nodeType(node)) {
+			closest = alternative.errors
+		}
+	}
+	return false, closest
+}
+
+func (v *schemaValidator) validateObject(s *schema, node *yaml.Node, path string) {
+	keys := []string{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keys = append(keys, key.Value)
+		keyPath := key.Value
+		if path != "" {
+			keyPath = path + "." + key.Value
+		}
+
+		if property, ok := s.Properties[key.Value]; ok {
+			v.validate(property, value, keyPath)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if s.AdditionalProperties.forbidden {
+			message := "unknown key"
+			if suggestion := closestKey(key.Value, s.Properties); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			v.fail(key, keyPath, "%s", message)
+			continue
+		}
+		if s.AdditionalProperties.schema != nil {
+			v.validate(s.AdditionalProperties.schema, value, keyPath)
+		}
+	}
+
+	for _, required := range s.Required {
+		if !slices.Contains(keys, required) {
+			v.fail(node, path, "missing %s", required)
+		}
+	}
+}
+
+// nodeType returns the JSON Schema type of a YAML node
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	}
+	return "string"
+}
+
+// closestKey returns the known key that is the most similar to key, for catching typos
+func closestKey(key string, properties map[string]*schema) string {
+	best, bestDistance := "", 3
+	for _, property := range slices.Sorted(maps.Keys(properties)) {
+		if distance := levenshtein(strings.ToLower(key), property); distance < bestDistance {
+			best, bestDistance = property, distance
+		}
+	}
+	return best
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
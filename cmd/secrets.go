@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+var secretsFile string
+
+// dockerSecretsFile is where Docker mounts a secret named tcpm_secrets
+const dockerSecretsFile = "/run/secrets/tcpm_secrets"
+
+// secrets is the secrets file, which keeps the tokens out of the config file
+type secrets struct {
+	// Tokens by user name
+	Tokens map[string]string `yaml:"tokens"`
+}
+
+// configDir returns the directory of the config file, relative paths in the config are relative to it
+func configDir() string {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return filepath.Dir(path)
+	}
+	return "."
+}
+
+// secretsPath returns the path of the secrets file, which may not exist yet.
+// Defaults to secrets.yaml next to the config file, or the Docker secret if only that exists.
+func secretsPath() string {
+	if secretsFile != "" {
+		return secretsFile
+	}
+	path := filepath.Join(configDir(), "secrets.yaml")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(dockerSecretsFile); err == nil {
+			return dockerSecretsFile
+		}
+	}
+	return path
+}
+
+// loadSecrets reads the secrets file, a missing file has no secrets
+func loadSecrets() (secrets, error) {
+	path := secretsPath()
+	var loaded secrets
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return loaded, nil
+	} else if err != nil {
+		return loaded, err
+	}
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		return loaded, fmt.Errorf("%s: %w", path, err)
+	}
+	return loaded, nil
+}
+
+// loadTokens returns the tokens of the secrets file and the token store, the token store takes precedence
+func loadTokens() (map[string]string, error) {
+	loaded, err := loadSecrets()
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets file: %w", err)
+	}
+	stored, err := loadStore()
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]string{}
+	maps.Copy(tokens, loaded.Tokens)
+	maps.Copy(tokens, stored)
+	return tokens, nil
+}
+
+// saveToken stores the token of the user in the secrets file, which is only readable by the owner
+func saveToken(name string, token string) error {
+	loaded, err := loadSecrets()
+	if err != nil {
+		return err
+	}
+	if loaded.Tokens == nil {
+		loaded.Tokens = map[string]string{}
+	}
+	loaded.Tokens[name] = token
+
+	var data bytes.Buffer
+	encoder := yaml.NewEncoder(&data)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(loaded); err != nil {
+		return err
+	}
+	return writeFileAtomic(secretsPath(), data.Bytes())
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with the environment variable, unset variables are an error
+func expandEnv(value string) (string, error) {
+	errs := []error{}
+	expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("environment variable %s is not set", name))
+		}
+		return env
+	})
+	return expanded, errors.Join(errs...)
+}
+
+// resolveToken returns the token of the user from token, token_file or the tokens of loadTokens, in that order
+func (c userConfig) resolveToken(tokens map[string]string) (string, error) {
+	if c.Token != "" {
+		return expandEnv(c.Token)
+	}
+	if c.TokenFile != "" {
+		path, err := expandEnv(c.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(configDir(), path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return tokens[c.Name], nil
+}
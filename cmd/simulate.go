@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateHost    string
+	simulatePort    int
+	simulateIRCPort int
+	simulateRun     bool
+)
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringVar(&simulateHost, "host", "127.0.0.1", "Host to bind the fake Twitch to")
+	simulateCmd.Flags().IntVarP(&simulatePort, "port", "p", 8090, "Port for GraphQL, PubSub, usher, spade and login")
+	simulateCmd.Flags().IntVar(&simulateIRCPort, "irc-port", 6667, "Port for the plain-text IRC server")
+	simulateCmd.Flags().BoolVarP(&simulateRun, "run", "r", false, "Also run the miner against the fake Twitch, using the script's users")
+}
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate [script.yaml]",
+	Short: "Run a fake Twitch backend",
+	Long: "Start a local fake of the Twitch services used by the miner and play a scripted timeline of events. " +
+		"Without a script, a small built-in scenario is used. Point the endpoints of a miner at it or use --run.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		script := simulator.DefaultScript()
+		if len(args) > 0 {
+			var err error
+			script, err = simulator.LoadScript(args[0])
+			cobra.CheckErr(err)
+		}
+
+		server := simulator.NewServer(script)
+		cobra.CheckErr(server.Start(
+			net.JoinHostPort(simulateHost, strconv.Itoa(simulatePort)),
+			net.JoinHostPort(simulateHost, strconv.Itoa(simulateIRCPort)),
+		))
+		defer func() {
+			_ = server.Close()
+		}()
+
+		endpoints := server.Endpoints()
+		cmd.Println("Add this to the config of the miner to use the fake Twitch:")
+		cmd.Println("")
+		cmd.Println("endpoints:")
+		cmd.Println("  website:", endpoints.Website)
+		cmd.Println("  gql:", endpoints.GraphQL)
+		cmd.Println("  usher:", endpoints.Usher)
+		cmd.Println("  id:", endpoints.ID)
+		cmd.Println("  pubsub:", endpoints.PubSub)
+		cmd.Println("  eventsub:", endpoints.EventSub)
+		cmd.Println("  helix:", endpoints.Helix)
+		cmd.Println("  irc:", endpoints.IRC)
+		cmd.Println("  irc_tls:", endpoints.IRCTLS)
+		cmd.Println("users:")
+		for _, user := range script.Users {
+			cmd.Println("  - name: " + user.Name)
+			cmd.Println("    token: " + user.Token)
+		}
+		cmd.Println("")
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		stopped := make(chan struct{})
+		if simulateRun {
+			go func() {
+				defer close(stopped)
+				if err := runSimulatedMiner(ctx, server); err != nil {
+					cmd.PrintErrln("Miner stopped:", err)
+					cancel()
+				}
+			}()
+		} else {
+			close(stopped)
+		}
+
+		server.Play(ctx)
+		// let the miner finish its pending requests before the fake Twitch goes away
+		<-stopped
+		fmt.Println("Calls:", server.Calls())
+	},
+}
+
+// runSimulatedMiner runs a miner with the current configuration but all traffic going to the simulator
+func runSimulatedMiner(ctx context.Context, server *simulator.Server) error {
+	// the users come from the script, the config users only provide their options
+	users, err := loadUsers()
+	if err != nil {
+		return err
+	}
+	options := loadOptions(users)
+	options.Endpoints = server.Endpoints()
+	options.PersistentFile = ""
+	options.PrometheusEnabled = false
+
+	instance, err := miner.New(miner.WithOptions(options))
+	if err != nil {
+		return err
+	}
+	for _, scriptUser := range server.Script().Users {
+		if err := addUser(instance, miner.NewUser(scriptUser.Name, scriptUser.Token), options); err != nil {
+			return err
+		}
+	}
+	return instance.Run(ctx)
+}
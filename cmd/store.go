@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	storeFile string
+	keyFile   string
+)
+
+const (
+	// passphraseEnv unlocks the token store with a passphrase
+	passphraseEnv = "TCPM_PASSPHRASE"
+	// keyFileEnv unlocks the token store with the content of a file
+	keyFileEnv = "TCPM_KEY_FILE"
+)
+
+var errNoKey = fmt.Errorf("no key to unlock the token store, set %s or %s or pass --key-file", passphraseEnv, keyFileEnv)
+
+var errWrongKey = errors.New("failed to unlock the token store, wrong passphrase or key file")
+
+// tokenStore is the encrypted token store, the tokens are encrypted with AES-256-GCM using a key derived by scrypt
+type tokenStore struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// additionalData binds the ciphertext to the key derivation parameters, so they can not be changed without breaking decryption
+func (s tokenStore) additionalData() []byte {
+	return fmt.Appendf(nil, "tcpm-tokens v%d %s n=%d r=%d p=%d", s.Version, s.KDF, s.N, s.R, s.P)
+}
+
+func (s tokenStore) cipher(passphrase []byte) (cipher.AEAD, error) {
+	if s.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation %q", s.KDF)
+	}
+	key, err := scrypt.Key(passphrase, s.Salt, s.N, s.R, s.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptTokens returns a new token store with fresh salt and nonce
+func encryptTokens(tokens map[string]string, passphrase []byte) ([]byte, error) {
+	store := tokenStore{Version: 1, KDF: "scrypt", Salt: make([]byte, 16), N: 1 << 15, R: 8, P: 1}
+	if _, err := rand.Read(store.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := store.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	store.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(store.Nonce); err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	store.Data = aead.Seal(nil, store.Nonce, plaintext, store.additionalData())
+	return json.MarshalIndent(store, "", "  ")
+}
+
+func decryptTokens(data []byte, passphrase []byte) (map[string]string, error) {
+	var store tokenStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	if store.Version != 1 {
+		return nil, fmt.Errorf("unsupported token store version %d", store.Version)
+	}
+	aead, err := store.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(store.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid token store nonce")
+	}
+	plaintext, err := aead.Open(nil, store.Nonce, store.Data, store.additionalData())
+	if err != nil {
+		return nil, errWrongKey
+	}
+	tokens := map[string]string{}
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	return tokens, nil
+}
+
+// storePath returns the path of the token store, which may not exist yet
+func storePath() string {
+	if storeFile != "" {
+		return storeFile
+	}
+	return filepath.Join(configDir(), "tokens.enc")
+}
+
+func storeExists() bool {
+	_, err := os.Stat(storePath())
+	return err == nil
+}
+
+// storeKey returns the passphrase of the token store from the environment or the key file
+func storeKey() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok && passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	path := keyFile
+	if path == "" {
+		path = os.Getenv(keyFileEnv)
+	}
+	if path == "" {
+		return nil, errNoKey
+	}
+	return readKeyFile(path)
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+	return []byte(key), nil
+}
+
+// loadStore returns the tokens of the token store, nothing if there is none
+func loadStore() (map[string]string, error) {
+	data, err := os.ReadFile(storePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key, err := storeKey()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", storePath(), err)
+	}
+	tokens, err := decryptTokens(data, key)
+	if errors.Is(err, errWrongKey) && os.Getenv(passphraseEnv) != "" {
+		// the passphrase is used even if there is a key file, e.g. after rotate-key switched to one
+		return nil, fmt.Errorf("%w: %s is set and used instead of the key file, update or unset it if the key was rotated", err, passphraseEnv)
+	}
+	return tokens, err
+}
+
+// saveStore encrypts the tokens and replaces the token store
+func saveStore(tokens map[string]string, passphrase []byte) error {
+	data, err := encryptTokens(tokens, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(storePath(), data)
+}
+
+// writeFileAtomic writes the file only readable by the owner, readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// CreateTemp already uses 0600, but the umask could have removed more
+	if err := file.Chmod(0o600); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testTokens = map[string]string{"alice": "token-alice", "bob": "token-bob"}
+
+// useTestStore points the token store and its keys at a temporary directory until the test ends
+func useTestStore(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	oldStore, oldKey, oldNewKey := storeFile, keyFile, newKeyFile
+	t.Cleanup(func() { storeFile, keyFile, newKeyFile = oldStore, oldKey, oldNewKey })
+	storeFile = filepath.Join(dir, "tokens.enc")
+	keyFile, newKeyFile = "", ""
+	t.Setenv(passphraseEnv, "")
+	t.Setenv(keyFileEnv, "")
+	t.Setenv(newPassphraseEnv, "")
+	return dir
+}
+
+func TestTokenStoreRoundTrip(t *testing.T) {
+	data, err := encryptTokens(testTokens, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "token-alice") {
+		t.Error("the token store contains a plaintext token")
+	}
+	tokens, err := decryptTokens(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(tokens, testTokens) {
+		t.Errorf("decrypted %v, want %v", tokens, testTokens)
+	}
+}
+
+func TestTokenStoreWrongPassphrase(t *testing.T) {
+	data, err := encryptTokens(testTokens, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptTokens(data, []byte("other")); !errors.Is(err, errWrongKey) {
+		t.Errorf("decrypting with the wrong passphrase returned %v, want %v", err, errWrongKey)
+	}
+}
+
+func TestTokenStoreTampered(t *testing.T) {
+	data, err := encryptTokens(testTokens, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var original tokenStore
+	if err := json.Unmarshal(data, &original); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := original.cipher([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		tamper func(store *tokenStore)
+		// parameters are part of the additional data, changing them fails even with the original key
+		parameter bool
+	}{
+		"ciphertext": {func(store *tokenStore) { store.Data[0] ^= 1 }, false},
+		"nonce":      {func(store *tokenStore) { store.Nonce[0] ^= 1 }, false},
+		"salt":       {func(store *tokenStore) { store.Salt[0] ^= 1 }, false},
+		"n":          {func(store *tokenStore) { store.N = 1 << 14 }, true},
+		"r":          {func(store *tokenStore) { store.R = 4 }, true},
+		"p":          {func(store *tokenStore) { store.P = 2 }, true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := original
+			store.Data, store.Nonce, store.Salt = slices.Clone(original.Data), slices.Clone(original.Nonce), slices.Clone(original.Salt)
+			test.tamper(&store)
+			tampered, err := json.Marshal(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := decryptTokens(tampered, []byte("passphrase")); !errors.Is(err, errWrongKey) {
+				t.Errorf("decrypting a tampered token store returned %v, want %v", err, errWrongKey)
+			}
+			if test.parameter {
+				if _, err := aead.Open(nil, store.Nonce, store.Data, store.additionalData()); err == nil {
+					t.Error("the additional data does not cover the parameter")
+				}
+			}
+		})
+	}
+}
+
+func TestRotateStoreKey(t *testing.T) {
+	dir := useTestStore(t)
+	if err := saveStore(testTokens, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.ReadFile(storeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// from the passphrase to a generated key file
+	t.Setenv(passphraseEnv, "old")
+	newKeyFile = filepath.Join(dir, "tcpm.key")
+	if err := rotateStoreKey(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.ReadFile(storeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptTokens(rotated, []byte("old")); !errors.Is(err, errWrongKey) {
+		t.Errorf("the old passphrase still unlocks the rotated token store: %v", err)
+	}
+	if info, err := os.Stat(newKeyFile); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file has permissions %v, want 0600", info.Mode().Perm())
+	}
+
+	// the stale passphrase is used instead of the key file and has to be named in the error
+	keyFile = newKeyFile
+	if _, err := loadStore(); !errors.Is(err, errWrongKey) || !strings.Contains(err.Error(), passphraseEnv) {
+		t.Errorf("loading with a stale %s returned %v", passphraseEnv, err)
+	}
+	t.Setenv(passphraseEnv, "")
+	tokens, err := loadStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(tokens, testTokens) {
+		t.Errorf("rotated token store has %v, want %v", tokens, testTokens)
+	}
+
+	// from the key file back to a passphrase
+	newKeyFile = ""
+	t.Setenv(newPassphraseEnv, "new")
+	if err := rotateStoreKey(); err != nil {
+		t.Fatal(err)
+	}
+	keyFile = ""
+	t.Setenv(passphraseEnv, "new")
+	tokens, err = loadStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(tokens, testTokens) {
+		t.Errorf("rotated token store has %v, want %v", tokens, testTokens)
+	}
+	if _, err := decryptTokens(old, []byte("new")); !errors.Is(err, errWrongKey) {
+		t.Errorf("the new passphrase unlocks the token store from before the rotation: %v", err)
+	}
+}
@@ -20,7 +20,7 @@ func (miner *Miner) Alert(data ...any) {
 		spew.Dump(d)
 	}
 
-	if miner.Options.DebugWebhook == "" {
+	if miner.GetOptions().DebugWebhook == "" {
 		return
 	}
 
@@ -39,7 +39,7 @@ func (miner *Miner) Alert(data ...any) {
 			if err != nil {
 				return
 			}
-			req, err = http.NewRequest("POST", miner.Options.DebugWebhook, bytes.NewBuffer(encoded))
+			req, err = http.NewRequest("POST", miner.GetOptions().DebugWebhook, bytes.NewBuffer(encoded))
 			if err == nil {
 				req.Header.Set("Content-Type", "application/json")
 			}
@@ -60,7 +60,7 @@ func (miner *Miner) Alert(data ...any) {
 			if err != nil {
 				return
 			}
-			req, err = http.NewRequest("POST", miner.Options.DebugWebhook, body)
+			req, err = http.NewRequest("POST", miner.GetOptions().DebugWebhook, body)
 			if err == nil {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 			}
@@ -71,7 +71,11 @@ func (miner *Miner) Alert(data ...any) {
 			return
 		}
 
-		res, err := miner.DefaultUser.GraphQL.Client.Do(req)
+		client := http.DefaultClient
+		if user := miner.GetDefaultUser(); user != nil {
+			client = user.GraphQL.Client
+		}
+		res, err := client.Do(req)
 		if err != nil {
 			fmt.Println("Error sending alert:", err)
 			return
@@ -82,7 +86,11 @@ func (miner *Miner) Alert(data ...any) {
 			body, _ := io.ReadAll(res.Body)
 			fmt.Println("Response body:", string(body))
 			_ = res.Body.Close()
-			time.Sleep(time.Second)
+			// a rejected alert, like a deleted webhook or a too long message, fails the same way every time
+			if res.StatusCode < http.StatusInternalServerError && res.StatusCode != http.StatusTooManyRequests {
+				return
+			}
+			miner.Clock.Sleep(time.Second)
 			continue
 		}
 		_ = res.Body.Close()
@@ -3,6 +3,7 @@ package miner
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -16,56 +17,117 @@ import (
 )
 
 type Chat struct {
-	user *User
+	user  *User
+	clock Clock
 
-	config         irc.ClientConfig
+	config irc.ClientConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// lock guards the connection state, which is changed by the connection while the scheduler revalidates channels
 	client         *irc.Client
-	ctx            context.Context
-	cancel         context.CancelFunc
 	isConnected    bool
 	joinedChannels []string
+	lock           sync.Mutex
 
 	channelState map[string]*channelState
 	chatLock     sync.Mutex
 }
 
 func (c *Chat) RunForever() {
-	for c.ctx != nil {
+	for c.ctx.Err() == nil {
 		fmt.Println("Connecting to chat...")
-		if err := c.connect(); err != nil {
+		if err := c.connect(); err != nil && c.ctx.Err() == nil {
 			fmt.Println("Chat connection error:", err)
-			time.Sleep(5 * time.Second)
+			c.user.Miner.Events.Publish(ConnectionLost{Kind: "chat", User: c.user, Err: err})
+			select {
+			case <-c.ctx.Done():
+			case <-c.clock.After(5 * time.Second):
+			}
 		}
 	}
 }
 
+// Stop leaves all joined channels and disconnects
 func (c *Chat) Stop() {
+	c.lock.Lock()
+	joined := c.joinedChannels
+	connected := c.isConnected
+	c.joinedChannels = []string{}
+	c.isConnected = false
+	c.lock.Unlock()
+
+	if connected && len(joined) > 0 {
+		fmt.Println("Leaving channels:", joined)
+		if err := c.leaveChannels(joined); err != nil {
+			fmt.Println("Failed to leave channels:", err)
+		}
+	}
 	c.cancel()
-	c.ctx = nil
+}
+
+// Leave leaves the channel if it was joined, it is joined again by RevalidateChannelSubscriptions if needed
+func (c *Chat) Leave(channel string) {
+	c.lock.Lock()
+	joined := c.isConnected && slices.Contains(c.joinedChannels, channel)
+	c.joinedChannels = slices.DeleteFunc(c.joinedChannels, func(joined string) bool {
+		return joined == channel
+	})
+	c.lock.Unlock()
+
+	c.chatLock.Lock()
+	delete(c.channelState, channel)
+	c.chatLock.Unlock()
+
+	if joined {
+		fmt.Println("Leaving channels:", []string{channel})
+		if err := c.leaveChannels([]string{channel}); err != nil {
+			fmt.Println("Failed to leave channels:", err)
+		}
+	}
 }
 
 func (c *Chat) connect() error {
+	c.lock.Lock()
 	c.isConnected = false
 	c.joinedChannels = []string{}
+	c.lock.Unlock()
 
-	conn, err := net.Dial("tcp", "irc.chat.twitch.tv:6697")
+	endpoints := c.user.Miner.GetOptions().Endpoints
+	conn, err := net.Dial("tcp", endpoints.IRC)
 	if err != nil {
 		return err
 	}
 
-	tlsConn := tls.Client(conn, &tls.Config{
-		ServerName: "irc.chat.twitch.tv",
-	})
+	if endpoints.IRCTLS {
+		host, _, err := net.SplitHostPort(endpoints.IRC)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+		conn = tls.Client(conn, &tls.Config{
+			ServerName: host,
+		})
+	}
 
 	config := c.config
 	config.Handler = irc.HandlerFunc(c.handler)
-	c.client = irc.NewClient(tlsConn, config)
+	client := irc.NewClient(conn, config)
+	c.lock.Lock()
+	c.client = client
+	c.lock.Unlock()
+
+	client.CapRequest("twitch.tv/commands", true)
+	client.CapRequest("twitch.tv/membership", true)
+	client.CapRequest("twitch.tv/tags", true)
 
-	c.client.CapRequest("twitch.tv/commands", true)
-	c.client.CapRequest("twitch.tv/membership", true)
-	c.client.CapRequest("twitch.tv/tags", true)
+	return client.RunContext(c.ctx)
+}
 
-	return c.client.RunContext(c.ctx)
+func (c *Chat) getClient() *irc.Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.client
 }
 
 func (c *Chat) handler(client *irc.Client, message *irc.Message) {
@@ -76,12 +138,19 @@ func (c *Chat) handler(client *irc.Client, message *irc.Message) {
 	var err error
 	switch message.Command {
 	case irc.RPL_ENDOFMOTD:
+		c.lock.Lock()
 		c.isConnected = true
+		c.lock.Unlock()
 		go c.onConnect()
 	case "PRIVMSG":
 		c.message(message)
 	case "PING":
-		err = c.ping(message)
+		err = c.ping(client, message)
+	case "NOTICE":
+		// Twitch closes the connection right after, reconnecting with the same token fails the same way
+		if notice := message.Trailing(); notice == "Login authentication failed" || notice == "Improperly formatted auth" {
+			go c.user.authFailed("chat", errors.New(notice))
+		}
 	case "CLEARCHAT_DISABLED": // the _DISABLED suffix is just so the clearChat handler is marked as dead code
 		// currently disabled because its spammy
 		c.clearChat(message)
@@ -96,13 +165,16 @@ func (c *Chat) onConnect() {
 }
 
 func (c *Chat) RevalidateChannelSubscriptions() {
+	c.lock.Lock()
 	if !c.isConnected {
+		c.lock.Unlock()
 		return
 	}
 	channels := []string{}
 	channelsToJoin := []string{}
-	for _, streamer := range c.user.Streamers {
-		if !streamer.IsLive() && c.user.Miner.Options.WatchTimeOnlyLive {
+	for _, streamer := range c.user.GetStreamers() {
+		options := c.user.Miner.GetOptions().ForUser(c.user.Username).ForStreamer(streamer.Username)
+		if !options.MineWatchtime || (!streamer.IsLive() && options.WatchTimeOnlyLive) {
 			continue
 		}
 		channel := streamer.ChannelName()
@@ -120,6 +192,8 @@ func (c *Chat) RevalidateChannelSubscriptions() {
 	}
 
 	c.joinedChannels = channels
+	c.lock.Unlock()
+
 	if len(channelsToJoin) > 0 {
 		fmt.Println("Joining channels:", channelsToJoin)
 		c.joinChannels(channelsToJoin)
@@ -142,30 +216,31 @@ func (c *Chat) joinChannels(channels []string) {
 			Params:  []string{strings.Join(channels[i:end], ",")},
 		}
 
-		if err := c.client.WriteMessage(message); err != nil {
+		if err := c.getClient().WriteMessage(message); err != nil {
 			fmt.Println("Failed to join channels:", err)
 		}
 
-		time.Sleep(12 * time.Second)
+		c.clock.Sleep(12 * time.Second)
 	}
 }
 
 func (c *Chat) leaveChannels(channels []string) error {
-	return c.client.WriteMessage(&irc.Message{
+	return c.getClient().WriteMessage(&irc.Message{
 		Command: "PART",
 		Params:  []string{strings.Join(channels, ",")},
 	})
 }
 
-func (c *Chat) ping(message *irc.Message) error {
+func (c *Chat) ping(client *irc.Client, message *irc.Message) error {
 	msg := message.Copy()
 	msg.Command = "PONG"
 
-	return c.client.WriteMessage(msg)
+	return client.WriteMessage(msg)
 }
 
 func (c *Chat) message(message *irc.Message) {
-	if !c.user.Miner.Options.FollowChatSpam {
+	channel := message.Params[0]
+	if !c.user.Miner.GetOptions().ForUser(c.user.Username).ForStreamer(strings.TrimPrefix(channel, "#")).FollowChatSpam {
 		return
 	}
 
@@ -181,11 +256,10 @@ func (c *Chat) message(message *irc.Message) {
 		return
 	}
 
-	channel := message.Params[0]
-
 	ch, ok := c.channelState[channel]
 	if !ok {
 		ch = &channelState{
+			clock:                c.clock,
 			lastMessageTime:      time.Time{},
 			messageFrequency:     map[string]int{},
 			messageSubCount:      map[string]int{},
@@ -206,15 +280,15 @@ func (c *Chat) message(message *irc.Message) {
 	}
 	uniqueSenders[message.Name] = struct{}{}
 
-	if time.Since(ch.lastReset) > 15*time.Second {
+	if c.clock.Since(ch.lastReset) > 15*time.Second {
 		ch.reset()
 	}
 
-	if ch.messageSubCount[content] > 1 && len(uniqueSenders) > 3 && content != ch.lastMessage && time.Since(ch.lastMessageTime) > 10*time.Second && !isSubscriber {
+	if ch.messageSubCount[content] > 1 && len(uniqueSenders) > 3 && content != ch.lastMessage && c.clock.Since(ch.lastMessageTime) > 10*time.Second && !isSubscriber {
 		// follow the emote spam
 		fmt.Println("Following spam:", content, "from", channel)
 		ch.lastMessage = content
-		ch.lastMessageTime = time.Now()
+		ch.lastMessageTime = c.clock.Now()
 		ch.reset()
 		chance := 0.6
 		if ch.totalMessages > 30 {
@@ -225,15 +299,16 @@ func (c *Chat) message(message *irc.Message) {
 		}
 		if rand.Float64() > chance {
 			go func() {
-				time.Sleep(time.Duration(rand.Float64() * 5 * float64(time.Second)))
-				if err := c.client.WriteMessage(&irc.Message{
+				c.clock.Sleep(time.Duration(rand.Float64() * 5 * float64(time.Second)))
+				if err := c.getClient().WriteMessage(&irc.Message{
 					Command: "PRIVMSG",
 					Params:  []string{channel, content},
 				}); err != nil {
 					fmt.Println("Failed to send chat message:", err)
+					return
 				}
+				c.user.Miner.Events.Publish(ChatSpamFollowed{User: c.user, Channel: channel, Message: content})
 			}()
-			go c.user.Miner.Alert(fmt.Sprintf("Followed spam: %s from %s", content, channel))
 		}
 	}
 }
@@ -269,18 +344,19 @@ func NewChat(u *User) *Chat {
 
 	return &Chat{
 		user:           u,
+		clock:          u.Miner.Clock,
 		config:         config,
-		client:         nil,
 		ctx:            ctx,
 		cancel:         cancel,
 		isConnected:    false,
 		joinedChannels: []string{},
 		channelState:   map[string]*channelState{},
-		chatLock:       sync.Mutex{},
 	}
 }
 
 type channelState struct {
+	clock Clock
+
 	lastMessageTime      time.Time
 	lastMessage          string
 	lastReset            time.Time
@@ -299,5 +375,5 @@ func (c *channelState) reset() {
 	} else {
 		c.totalMessages -= 80
 	}
-	c.lastReset = time.Now()
+	c.lastReset = c.clock.Now()
 }
@@ -0,0 +1,169 @@
+package miner
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything time-based in the miner, so tests can fast-forward with a FakeClock
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Until(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f in its own goroutine after d
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if it already fired or was stopped
+	Stop() bool
+}
+
+// RealClock uses the time package
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (RealClock) Until(t time.Time) time.Duration        { return time.Until(t) }
+func (RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+// FakeClock only moves when Advance or Set is called. Sleeps and timers fire once the time passed their deadline.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	lock   sync.Mutex
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	ch    chan time.Time
+	fn    func()
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Since(t time.Time) time.Duration { return c.Now().Sub(t) }
+func (c *FakeClock) Until(t time.Time) time.Duration { return t.Sub(c.Now()) }
+func (c *FakeClock) Sleep(d time.Duration)           { <-c.After(d) }
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.addTimer(d, nil)
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.addTimer(d, f)
+}
+
+func (c *FakeClock) addTimer(d time.Duration, f func()) *fakeTimer {
+	c.lock.Lock()
+	timer := &fakeTimer{c, c.now.Add(d), make(chan time.Time, 1), f}
+	if d > 0 {
+		c.timers = append(c.timers, timer)
+		c.lock.Unlock()
+		return timer
+	}
+	now := c.now
+	c.lock.Unlock()
+
+	timer.fire(now)
+	return timer
+}
+
+// Advance moves the clock forward and fires all timers that are due, in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t and fires all timers that are due, in order
+func (c *FakeClock) Set(t time.Time) {
+	c.lock.Lock()
+	c.now = t
+	due := []*fakeTimer{}
+	c.timers = slices.DeleteFunc(c.timers, func(timer *fakeTimer) bool {
+		if timer.at.After(t) {
+			return false
+		}
+		due = append(due, timer)
+		return true
+	})
+	c.lock.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int {
+		return a.at.Compare(b.at)
+	})
+	for _, timer := range due {
+		timer.fire(t)
+	}
+}
+
+// Waiters returns how many sleeps and timers are pending.
+// Tests can poll it to know a goroutine reached its sleep before calling Advance
+func (c *FakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	t.ch <- now
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	index := slices.Index(t.clock.timers, t)
+	if index == -1 {
+		return false
+	}
+	t.clock.timers = slices.Delete(t.clock.timers, index, index+1)
+	return true
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// clockOrReal is for structs that can be used without a clock, like a Streamer created in a test
+func clockOrReal(clock Clock) Clock {
+	if clock == nil {
+		return RealClock{}
+	}
+	return clock
+}
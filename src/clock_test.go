@@ -0,0 +1,111 @@
+package miner_test
+
+import (
+	"testing"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+)
+
+var start = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// fired returns whether the timer fired, without waiting for it
+func fired(timer miner.Timer) bool {
+	select {
+	case <-timer.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClockAdvance(t *testing.T) {
+	clock := miner.NewFakeClock(start)
+	third := clock.NewTimer(3 * time.Second)
+	first := clock.NewTimer(time.Second)
+	second := clock.NewTimer(2 * time.Second)
+	if clock.Waiters() != 3 {
+		t.Fatalf("%d waiters, want 3", clock.Waiters())
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if fired(first) || fired(second) || fired(third) {
+		t.Fatal("timers fired before their deadline")
+	}
+	clock.Advance(time.Millisecond)
+	if !fired(first) || fired(second) || fired(third) {
+		t.Fatal("only the first timer should fire at its deadline")
+	}
+	clock.Advance(5 * time.Second)
+	if !fired(second) || !fired(third) {
+		t.Fatal("all due timers should fire")
+	}
+	if clock.Waiters() != 0 {
+		t.Errorf("%d waiters after all timers fired", clock.Waiters())
+	}
+	if got := clock.Now(); !got.Equal(start.Add(6 * time.Second)) {
+		t.Errorf("now is %s, want %s", got, start.Add(6*time.Second))
+	}
+
+	// timers that are already due fire right away
+	if !fired(clock.NewTimer(0)) {
+		t.Error("a timer without delay should fire right away")
+	}
+}
+
+func TestFakeClockStop(t *testing.T) {
+	clock := miner.NewFakeClock(start)
+	stopped := clock.NewTimer(time.Second)
+	kept := clock.NewTimer(time.Second)
+
+	if !stopped.Stop() {
+		t.Error("stopping a pending timer should return true")
+	}
+	if stopped.Stop() {
+		t.Error("stopping a timer twice should return false")
+	}
+	if clock.Waiters() != 1 {
+		t.Errorf("%d waiters, want 1", clock.Waiters())
+	}
+
+	clock.Advance(time.Second)
+	if fired(stopped) {
+		t.Error("a stopped timer fired")
+	}
+	if !fired(kept) {
+		t.Error("the timer that was not stopped should fire")
+	}
+	if kept.Stop() {
+		t.Error("stopping a fired timer should return false")
+	}
+}
+
+func TestFakeClockAfterFunc(t *testing.T) {
+	clock := miner.NewFakeClock(start)
+	calls := make(chan time.Time, 2)
+	clock.AfterFunc(time.Minute, func() { calls <- clock.Now() })
+	stopped := clock.AfterFunc(time.Minute, func() { calls <- time.Time{} })
+	stopped.Stop()
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-calls:
+		t.Fatal("function was called before its deadline")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case at := <-calls:
+		if !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("function saw %s, want %s", at, start.Add(time.Minute))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("function was not called")
+	}
+	select {
+	case <-calls:
+		t.Error("function of the stopped timer was called")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
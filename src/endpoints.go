@@ -0,0 +1,64 @@
+package miner
+
+// Endpoints contains the base URLs of every Twitch service the miner talks to.
+// They can be pointed at a local stand-in (for example `tcpm simulate`) for testing.
+type Endpoints struct {
+	// Website is used to scrape the client version and spade url
+	Website string
+	GraphQL string
+	Usher   string
+	ID      string
+	// PubSub accepts ws:// for non-TLS servers
+	PubSub string
+	// EventSub and Helix are only used with the eventsub transport
+	EventSub string
+	Helix    string
+	// IRC is a host:port pair, IRCTLS controls whether we use TLS or plain TCP
+	IRC    string
+	IRCTLS bool
+}
+
+func DefaultEndpoints() Endpoints {
+	return Endpoints{
+		Website:  "https://www.twitch.tv",
+		GraphQL:  "https://gql.twitch.tv/gql",
+		Usher:    "https://usher.ttvnw.net",
+		ID:       "https://id.twitch.tv",
+		PubSub:   "wss://pubsub-edge.twitch.tv",
+		EventSub: "wss://eventsub.wss.twitch.tv/ws",
+		Helix:    "https://api.twitch.tv/helix",
+		IRC:      "irc.chat.twitch.tv:6697",
+		IRCTLS:   true,
+	}
+}
+
+// WithDefaults fills in all empty endpoints with their default value
+func (e Endpoints) WithDefaults() Endpoints {
+	defaults := DefaultEndpoints()
+	if e.Website == "" {
+		e.Website = defaults.Website
+	}
+	if e.GraphQL == "" {
+		e.GraphQL = defaults.GraphQL
+	}
+	if e.Usher == "" {
+		e.Usher = defaults.Usher
+	}
+	if e.ID == "" {
+		e.ID = defaults.ID
+	}
+	if e.PubSub == "" {
+		e.PubSub = defaults.PubSub
+	}
+	if e.EventSub == "" {
+		e.EventSub = defaults.EventSub
+	}
+	if e.Helix == "" {
+		e.Helix = defaults.Helix
+	}
+	if e.IRC == "" {
+		e.IRC = defaults.IRC
+		e.IRCTLS = defaults.IRCTLS
+	}
+	return e
+}
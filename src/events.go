@@ -0,0 +1,268 @@
+package miner
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Event is something that happened while mining. Subsystems publish events on the EventBus instead of
+// calling alerts, metrics or persistence directly, so every integration gets the same structured data.
+type Event interface {
+	// EventName is a stable identifier, like "points_earned"
+	EventName() string
+}
+
+// PointsEarned is published for every points gain, Balance is the new balance of the user
+type PointsEarned struct {
+	User     *User
+	Streamer *Streamer
+	Points   int
+	Reason   string
+	Balance  int
+}
+
+func (e PointsEarned) EventName() string { return "points_earned" }
+
+func (e PointsEarned) String() string {
+	return fmt.Sprintf("+%d points from %s (%s)", e.Points, e.Reason, e.Streamer.Username)
+}
+
+// ClaimCollected is published once a bonus claim was sent successfully
+type ClaimCollected struct {
+	User     *User
+	Streamer *Streamer
+	ClaimID  string
+}
+
+func (e ClaimCollected) EventName() string { return "claim_collected" }
+
+func (e ClaimCollected) String() string {
+	return fmt.Sprintf("Claimed bonus on %s for %s", e.Streamer.Username, e.User.Username)
+}
+
+// BetPlaced is published once a bet was accepted
+type BetPlaced struct {
+	User         *User
+	Streamer     *Streamer
+	PredictionID string
+	Title        string
+	OutcomeID    string
+	Outcome      string
+	Points       int
+}
+
+func (e BetPlaced) EventName() string { return "bet_placed" }
+
+func (e BetPlaced) String() string {
+	return fmt.Sprintf("Betting %d points on %s (%s) for %s", e.Points, e.Outcome, e.OutcomeID, e.Title)
+}
+
+// PredictionResolved is published when a prediction ended with a winner
+type PredictionResolved struct {
+	Streamer     *Streamer
+	PredictionID string
+	Title        string
+	Winner       string
+	// key is the identifier used to remember results of recurring predictions, see predictionModel.PredictionID
+	key string
+}
+
+func (e PredictionResolved) EventName() string { return "prediction_resolved" }
+
+func (e PredictionResolved) String() string {
+	return fmt.Sprintf("Prediction %s resolved, %s won", e.Title, e.Winner)
+}
+
+// RaidJoined is published once a user joined a raid, Streamer is the raiding streamer
+type RaidJoined struct {
+	User     *User
+	Streamer *Streamer
+	RaidID   string
+}
+
+func (e RaidJoined) EventName() string { return "raid_joined" }
+
+func (e RaidJoined) String() string {
+	return fmt.Sprintf("Joined raid of %s for %s", e.Streamer.Username, e.User.Username)
+}
+
+type StreamUp struct {
+	Streamer *Streamer
+}
+
+func (e StreamUp) EventName() string { return "stream_up" }
+func (e StreamUp) String() string    { return e.Streamer.Username + " went live" }
+
+type StreamDown struct {
+	Streamer *Streamer
+}
+
+func (e StreamDown) EventName() string { return "stream_down" }
+func (e StreamDown) String() string    { return e.Streamer.Username + " went offline" }
+
+// ChatSpamFollowed is published once a message following the chat spam was sent
+type ChatSpamFollowed struct {
+	User    *User
+	Channel string
+	Message string
+}
+
+func (e ChatSpamFollowed) EventName() string { return "chat_spam_followed" }
+
+func (e ChatSpamFollowed) String() string {
+	return fmt.Sprintf("Followed spam: %s from %s", e.Message, e.Channel)
+}
+
+// ConnectionLost is published when a connection to Twitch died unexpectedly, it is reconnected automatically
+type ConnectionLost struct {
+	// Kind is "pubsub", "eventsub" or "chat"
+	Kind string
+	// User is set for chat connections
+	User *User
+	Err  error
+}
+
+func (e ConnectionLost) EventName() string { return "connection_lost" }
+
+func (e ConnectionLost) String() string {
+	if e.User != nil {
+		return fmt.Sprintf("Lost %s connection of %s: %v", e.Kind, e.User.Username, e.Err)
+	}
+	return fmt.Sprintf("Lost %s connection: %v", e.Kind, e.Err)
+}
+
+// AuthFailed is published once Twitch rejected the token of a user, the user is paused until it gets a new token
+type AuthFailed struct {
+	User *User
+	// Err tells which connection the token was rejected by
+	Err error
+}
+
+func (e AuthFailed) EventName() string { return "auth_failed" }
+
+func (e AuthFailed) String() string {
+	return fmt.Sprintf("Paused %s, Twitch rejected the token (%v). Log in again with `login --save` or put a new token into the config, mining resumes once it is loaded.", e.User.Username, e.Err)
+}
+
+// AuthRestored is published when a paused user was added again with a working token
+type AuthRestored struct {
+	User *User
+}
+
+func (e AuthRestored) EventName() string { return "auth_restored" }
+
+func (e AuthRestored) String() string {
+	return fmt.Sprintf("Resumed %s with the new token", e.User.Username)
+}
+
+// EventBus delivers every published event to all subscribers.
+// Each subscriber has its own goroutine, so a slow subscriber (like a webhook) never blocks the publisher
+// or the other subscribers, and sees the events in the order they were published.
+// A subscriber that falls too far behind misses events instead.
+type EventBus struct {
+	subscribers []chan Event
+	closed      bool
+	dropped     atomic.Int64
+	lock        sync.RWMutex
+	wg          sync.WaitGroup
+}
+
+// Subscribe calls handler for every event published from now on
+func (b *EventBus) Subscribe(handler func(Event)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return
+	}
+	events := make(chan Event, 100)
+	b.subscribers = append(b.subscribers, events)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range events {
+			handler(event)
+		}
+	}()
+}
+
+func (b *EventBus) Publish(event Event) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.closed {
+		return
+	}
+	for _, events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			b.dropped.Add(1)
+			fmt.Println("Dropping", event.EventName(), "event, a subscriber is too slow")
+		}
+	}
+}
+
+// Dropped returns how many events were not delivered to a subscriber because its buffer was full
+func (b *EventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+// Close stops accepting events and waits until the subscribers handled everything published before
+func (b *EventBus) Close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	for _, events := range b.subscribers {
+		close(events)
+	}
+	b.lock.Unlock()
+
+	b.wg.Wait()
+}
+
+// On subscribes to events of type T only
+func On[T Event](bus *EventBus, handler func(T)) {
+	bus.Subscribe(func(event Event) {
+		if e, ok := event.(T); ok {
+			handler(e)
+		}
+	})
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: []chan Event{}}
+}
+
+// subscribeAlerts sends the events that used to be alerted directly to the alert webhook
+func (miner *Miner) subscribeAlerts() {
+	miner.Events.Subscribe(func(event Event) {
+		switch event.(type) {
+		case PointsEarned, BetPlaced, ChatSpamFollowed, AuthFailed, AuthRestored:
+			miner.Alert(event.(fmt.Stringer).String())
+		}
+	})
+}
+
+// subscribePersistence remembers prediction results, the CAUTIOUS strategy bets based on them
+func (miner *Miner) subscribePersistence() {
+	On(miner.Events, func(event PredictionResolved) {
+		miner.Lock.Lock()
+		defer miner.Lock.Unlock()
+
+		data, ok := miner.Persistent.PredictionResults[event.key]
+		if !ok {
+			data = map[string]int{}
+			miner.Persistent.PredictionResults[event.key] = data
+		}
+		data[event.Winner]++
+
+		if err := miner.Persistent.Save(miner.GetOptions()); err != nil {
+			fmt.Println("Failed to save prediction results", err)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package miner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type eventSubMessage struct {
+	Metadata struct {
+		MessageID           string `json:"message_id"`
+		MessageType         string `json:"message_type"`
+		SubscriptionType    string `json:"subscription_type"`
+		SubscriptionVersion string `json:"subscription_version"`
+	} `json:"metadata"`
+	Payload struct {
+		Session *struct {
+			ID                      string  `json:"id"`
+			Status                  string  `json:"status"`
+			KeepaliveTimeoutSeconds int     `json:"keepalive_timeout_seconds"`
+			ReconnectURL            *string `json:"reconnect_url"`
+		} `json:"session"`
+		Subscription *struct {
+			ID     string `json:"id"`
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+type eventSubStreamEvent struct {
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+}
+
+type eventSubRaidEvent struct {
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	Viewers                  int    `json:"viewers"`
+}
+
+type eventSubPredictionEvent struct {
+	ID                string     `json:"id"`
+	BroadcasterUserID string     `json:"broadcaster_user_id"`
+	Title             string     `json:"title"`
+	WinningOutcomeID  *string    `json:"winning_outcome_id"`
+	Status            string     `json:"status"`
+	StartedAt         time.Time  `json:"started_at"`
+	LocksAt           time.Time  `json:"locks_at"`
+	LockedAt          *time.Time `json:"locked_at"`
+	EndedAt           *time.Time `json:"ended_at"`
+	Outcomes          []struct {
+		ID            string `json:"id"`
+		Title         string `json:"title"`
+		Color         string `json:"color"`
+		Users         int    `json:"users"`
+		ChannelPoints int    `json:"channel_points"`
+		TopPredictors []struct {
+			ChannelPointsUsed int `json:"channel_points_used"`
+		} `json:"top_predictors"`
+	} `json:"outcomes"`
+}
+
+// toWebsocketMessage maps an EventSub notification to the PubSub message Miner.OnMessage understands
+func (message *eventSubMessage) toWebsocketMessage() (*WebsocketMessage, error) {
+	var topic, messageType, id string
+	var data any
+
+	switch message.Metadata.SubscriptionType {
+	case "stream.online", "stream.offline":
+		var event eventSubStreamEvent
+		if err := json.Unmarshal(message.Payload.Event, &event); err != nil {
+			return nil, err
+		}
+		topic, id = "video-playback-by-id", event.BroadcasterUserID
+		messageType = "stream-up"
+		if message.Metadata.SubscriptionType == "stream.offline" {
+			messageType = "stream-down"
+		}
+		data = map[string]any{"type": messageType}
+	case "channel.raid":
+		var event eventSubRaidEvent
+		if err := json.Unmarshal(message.Payload.Event, &event); err != nil {
+			return nil, err
+		}
+		// EventSub only notifies about finished raids and has no raid id, so there is nothing to join
+		topic, id, messageType = "raid", event.FromBroadcasterUserID, "raid_update_v2"
+		data = map[string]any{
+			"type": messageType,
+			"raid": map[string]any{
+				"id":           "",
+				"source_id":    event.FromBroadcasterUserID,
+				"target_id":    event.ToBroadcasterUserID,
+				"target_login": event.ToBroadcasterUserLogin,
+				"viewer_count": event.Viewers,
+			},
+		}
+	case "channel.prediction.begin", "channel.prediction.progress", "channel.prediction.lock", "channel.prediction.end":
+		var event eventSubPredictionEvent
+		if err := json.Unmarshal(message.Payload.Event, &event); err != nil {
+			return nil, err
+		}
+		topic, id = "predictions-channel-v1", event.BroadcasterUserID
+		messageType = "event-updated"
+		if message.Metadata.SubscriptionType == "channel.prediction.begin" {
+			messageType = "event-created"
+		}
+		data = map[string]any{
+			"type": messageType,
+			"data": map[string]any{"event": event.toPredictionModel(message.Metadata.SubscriptionType)},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported subscription type %s", message.Metadata.SubscriptionType)
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &WebsocketMessage{[]string{topic, id}, messageType, encoded}, nil
+}
+
+func (event *eventSubPredictionEvent) toPredictionModel(subscriptionType string) predictionModel {
+	model := predictionModel{
+		Status:                  PredictionStatusActive,
+		WinningOutcomeID:        event.WinningOutcomeID,
+		ChannelID:               event.BroadcasterUserID,
+		CreatedAt:               event.StartedAt,
+		PredictionWindowSeconds: int(event.LocksAt.Sub(event.StartedAt).Seconds()),
+		Title:                   event.Title,
+		ID:                      event.ID,
+	}
+	if event.LockedAt != nil {
+		model.LockedAt = *event.LockedAt
+	}
+	if event.EndedAt != nil {
+		model.EndedAt = *event.EndedAt
+	}
+
+	switch subscriptionType {
+	case "channel.prediction.lock":
+		model.Status = PredictionStatusLocked
+	case "channel.prediction.end":
+		model.Status = PredictionStatusResolved
+		if event.Status == "canceled" {
+			model.Status = PredictionStatusCanceled
+		}
+	}
+
+	for _, outcome := range event.Outcomes {
+		converted := predictionOutcome{
+			ID:          outcome.ID,
+			Color:       strings.ToUpper(outcome.Color),
+			Title:       outcome.Title,
+			TotalPoints: outcome.ChannelPoints,
+			TotalUsers:  outcome.Users,
+		}
+		for _, predictor := range outcome.TopPredictors {
+			converted.TopPredictors = append(converted.TopPredictors, struct {
+				Points int `json:"points"`
+			}{predictor.ChannelPointsUsed})
+		}
+		model.Outcomes = append(model.Outcomes, converted)
+	}
+	return model
+}
+
+// eventSubSubscriptions maps a PubSub topic to the EventSub subscription types replacing it.
+// Prediction subscriptions need the authorization of the broadcaster, without it they fail and the topic falls back to PubSub.
+func eventSubSubscriptions(topic *WebsocketTopic) []eventSubSubscription {
+	if topic.Streamer == nil {
+		return nil
+	}
+	broadcaster := map[string]string{"broadcaster_user_id": topic.Streamer.ID}
+
+	switch topic.Topic {
+	case "video-playback-by-id":
+		return []eventSubSubscription{
+			{"stream.online", "1", broadcaster},
+			{"stream.offline", "1", broadcaster},
+		}
+	case "raid":
+		return []eventSubSubscription{
+			{"channel.raid", "1", map[string]string{"from_broadcaster_user_id": topic.Streamer.ID}},
+		}
+	case "predictions-channel-v1":
+		return []eventSubSubscription{
+			{"channel.prediction.begin", "1", broadcaster},
+			{"channel.prediction.progress", "1", broadcaster},
+			{"channel.prediction.lock", "1", broadcaster},
+			{"channel.prediction.end", "1", broadcaster},
+		}
+	}
+	return nil
+}
+
+type eventSubSubscription struct {
+	Type      string
+	Version   string
+	Condition map[string]string
+}
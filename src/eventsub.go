@@ -0,0 +1,556 @@
+package miner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// EventSub has no viewcount, so the viewers of live streamers are polled instead
+	eventSubViewerPollInterval = time.Minute
+	// added on top of the keepalive timeout twitch tells us before we consider the session dead
+	eventSubKeepaliveGrace = 5 * time.Second
+)
+
+// EventSubConnection is an alternative to PubSub for the streamer topics (stream up/down, raids and predictions).
+// User topics like community points have no EventSub equivalent and stay on PubSub.
+//
+// WebSocket sessions have a low subscription cost limit, topics that can not be subscribed to are handed to the fallback transport.
+type EventSubConnection struct {
+	url      string
+	helixURL string
+	miner    *Miner
+	// user is the default user of the miner when the session was started, subscriptions are created with its token
+	user     *User
+	clock    Clock
+	messages *messageQueue
+	closed   bool
+	fallback Transport
+
+	conn      *websocket.Conn
+	sessionID string
+	// reconnecting is set while a reconnect waits to retry, there is only ever one so they don't start parallel sessions
+	reconnecting     bool
+	keepaliveTimeout time.Duration
+	lastMessage      time.Time
+
+	topics        []*WebsocketTopic
+	subscriptions map[*WebsocketTopic][]string
+	live          map[*Streamer]bool
+	// fallenBack are the topics handed to the fallback transport
+	fallenBack []*WebsocketTopic
+
+	lock sync.Mutex
+}
+
+// Supports returns whether the topic can be handled by EventSub
+func (es *EventSubConnection) Supports(topic *WebsocketTopic) bool {
+	return len(eventSubSubscriptions(topic)) > 0
+}
+
+func (es *EventSubConnection) Connect() error {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	return es.connect(es.url)
+}
+
+// connect dials the url and waits for the welcome message. Must hold the lock
+func (es *EventSubConnection) connect(url string) error {
+	conn, err := websocket.Dial(url, "", es.miner.GetOptions().Endpoints.Website)
+	if err != nil {
+		return err
+	}
+
+	var welcome eventSubMessage
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if err := websocket.JSON.Receive(conn, &welcome); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to read welcome: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if welcome.Metadata.MessageType != "session_welcome" || welcome.Payload.Session == nil {
+		_ = conn.Close()
+		return fmt.Errorf("expected session_welcome, got %s", welcome.Metadata.MessageType)
+	}
+
+	oldConn := es.conn
+	resumed := oldConn != nil && welcome.Payload.Session.ID == es.sessionID
+	es.conn = conn
+	es.sessionID = welcome.Payload.Session.ID
+	es.keepaliveTimeout = time.Duration(welcome.Payload.Session.KeepaliveTimeoutSeconds) * time.Second
+	es.lastMessage = es.clock.Now()
+	es.log("Connected, session", es.sessionID, "keepalive", es.keepaliveTimeout)
+
+	if oldConn != nil {
+		_ = oldConn.Close()
+	}
+	go es.handleMessages(conn)
+	go es.watchKeepalive(conn)
+
+	if !resumed {
+		// a new session starts without any subscriptions, topics failing to subscribe are removed while iterating
+		es.subscriptions = map[*WebsocketTopic][]string{}
+		for _, topic := range slices.Clone(es.topics) {
+			es.subscribe(topic)
+		}
+	}
+	return nil
+}
+
+func (es *EventSubConnection) handleMessages(conn *websocket.Conn) {
+	for {
+		var message eventSubMessage
+		if err := websocket.JSON.Receive(conn, &message); err != nil {
+			es.lock.Lock()
+			current := es.conn == conn
+			es.lock.Unlock()
+			if current {
+				es.log("Error reading message", err)
+				es.miner.Events.Publish(ConnectionLost{Kind: "eventsub", Err: err})
+				es.reconnect("")
+			}
+			return
+		}
+
+		es.lock.Lock()
+		es.lastMessage = es.clock.Now()
+		es.lock.Unlock()
+
+		switch message.Metadata.MessageType {
+		case "session_keepalive":
+		case "session_reconnect":
+			// twitch moves us to another server, subscriptions carry over
+			if message.Payload.Session != nil && message.Payload.Session.ReconnectURL != nil {
+				es.log("Received signal to reconnect")
+				go es.reconnect(*message.Payload.Session.ReconnectURL)
+			}
+		case "revocation":
+			if message.Payload.Subscription != nil {
+				es.log("Subscription revoked", message.Payload.Subscription.Type, message.Payload.Subscription.Status)
+			}
+		case "notification":
+			converted, err := message.toWebsocketMessage()
+			if err != nil {
+				es.log("Error converting notification", err)
+				continue
+			}
+			es.log("Received notification", message.Metadata.SubscriptionType, string(message.Payload.Event))
+			es.trackLive(converted)
+			es.messages.send(*converted)
+		default:
+			es.log("Received unknown message", message.Metadata.MessageType)
+		}
+	}
+}
+
+// reconnect connects to the reconnect url (keeping the session) or starts a new session if url is empty
+func (es *EventSubConnection) reconnect(url string) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	if es.closed || es.reconnecting {
+		return
+	}
+	if url != "" {
+		err := es.connect(url)
+		if err == nil {
+			return
+		}
+		// a failed reconnect url is useless, start over with a new session
+		es.log("Error connecting to the reconnect url", err)
+	}
+	if es.conn != nil {
+		_ = es.conn.Close()
+	}
+	es.conn = nil
+	es.refreshUser()
+	es.retryConnect()
+}
+
+// retryConnect starts a new session, backing off between attempts until a session exists or the connection is closed. Must hold the lock
+func (es *EventSubConnection) retryConnect() {
+	if es.reconnecting {
+		return
+	}
+	es.reconnecting = true
+	defer func() { es.reconnecting = false }()
+
+	for attempt := 1; !es.closed && es.conn == nil; attempt++ {
+		err := es.connect(es.url)
+		if err == nil {
+			return
+		}
+		delay := jitteredBackoff(attempt, minConnectBackoff, maxConnectBackoff)
+		es.log("Error reconnecting, retrying in", delay.Round(time.Second), err)
+
+		es.lock.Unlock()
+		es.clock.Sleep(delay)
+		es.lock.Lock()
+		es.refreshUser()
+	}
+}
+
+// refreshUser makes a new session use the current default user of the miner. Must hold the lock
+func (es *EventSubConnection) refreshUser() {
+	if user := es.miner.GetDefaultUser(); user != nil && user != es.user {
+		es.log("Using the token of", user.Username)
+		es.user = user
+	}
+}
+
+// useDefaultUser starts a new session if the default user of the miner changed, the subscriptions of the current session belong to the token of the old one
+func (es *EventSubConnection) useDefaultUser() {
+	es.lock.Lock()
+	user := es.miner.GetDefaultUser()
+	if es.closed || user == nil || user == es.user {
+		es.lock.Unlock()
+		return
+	}
+	if es.conn == nil {
+		// not connected yet or already reconnecting, the next session uses the new user
+		es.refreshUser()
+		es.lock.Unlock()
+		return
+	}
+	es.lock.Unlock()
+	es.reconnect("")
+}
+
+func (es *EventSubConnection) watchKeepalive(conn *websocket.Conn) {
+	for {
+		es.lock.Lock()
+		if es.conn != conn {
+			es.lock.Unlock()
+			return
+		}
+		deadline := es.lastMessage.Add(es.keepaliveTimeout + eventSubKeepaliveGrace)
+		es.lock.Unlock()
+
+		if es.clock.Now().After(deadline) {
+			es.log("Did not receive keepalive, reconnecting")
+			es.miner.Events.Publish(ConnectionLost{Kind: "eventsub", Err: fmt.Errorf("no keepalive since %s", deadline)})
+			es.reconnect("")
+			return
+		}
+		es.clock.Sleep(es.clock.Until(deadline))
+	}
+}
+
+func (es *EventSubConnection) Listen(topics ...*WebsocketTopic) error {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	if es.closed {
+		return fmt.Errorf("eventsub connection is closed")
+	}
+
+	for _, topic := range topics {
+		es.topics = append(es.topics, topic)
+		topic.State = TopicStatePending
+		if es.conn != nil {
+			es.subscribe(topic)
+		}
+	}
+
+	// a pending reconnect subscribes to the topics once it has a session
+	if es.conn == nil && !es.reconnecting {
+		if err := es.connect(es.url); err != nil {
+			go func() {
+				es.lock.Lock()
+				defer es.lock.Unlock()
+				es.retryConnect()
+			}()
+			return err
+		}
+	}
+	return nil
+}
+
+func (es *EventSubConnection) Unlisten(topics ...*WebsocketTopic) error {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	errs := []error{}
+	fallenBack := []*WebsocketTopic{}
+	for _, topic := range topics {
+		if index := slices.Index(es.fallenBack, topic); index != -1 {
+			es.fallenBack = slices.Delete(es.fallenBack, index, index+1)
+			fallenBack = append(fallenBack, topic)
+			continue
+		}
+		index := slices.Index(es.topics, topic)
+		if index == -1 {
+			continue
+		}
+		es.topics = slices.Delete(es.topics, index, index+1)
+		for _, id := range es.subscriptions[topic] {
+			if err := es.deleteSubscription(id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(es.subscriptions, topic)
+		if topic.Topic == "video-playback-by-id" {
+			delete(es.live, topic.Streamer)
+		}
+	}
+	if len(fallenBack) > 0 {
+		if err := es.fallback.Unlisten(fallenBack...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors deleting subscriptions: %v", errs)
+	}
+	return nil
+}
+
+func (es *EventSubConnection) Messages() <-chan WebsocketMessage {
+	return es.messages.ch
+}
+
+// Close disconnects and drops the session, which deletes all subscriptions on Twitch's side as well
+func (es *EventSubConnection) Close() error {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	es.closed = true
+	var err error
+	if es.conn != nil {
+		err = es.conn.Close()
+		es.conn = nil
+	}
+	es.messages.close()
+	return err
+}
+
+// Topics returns a snapshot of all topics handled by EventSub, Connection is always -1
+func (es *EventSubConnection) Topics() []TopicStatus {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	statuses := make([]TopicStatus, 0, len(es.topics))
+	for _, topic := range es.topics {
+		statuses = append(statuses, TopicStatus{
+			Name:       topic.GetTopicName(),
+			State:      topic.State,
+			Error:      topic.Error,
+			Connection: -1,
+		})
+	}
+	return statuses
+}
+
+// subscribe creates the subscriptions for a topic, if that fails the topic is handed to the fallback. Must hold the lock
+func (es *EventSubConnection) subscribe(topic *WebsocketTopic) {
+	ids := []string{}
+	for _, subscription := range eventSubSubscriptions(topic) {
+		id, err := es.createSubscription(subscription)
+		if err != nil {
+			es.log("Failed to subscribe to", subscription.Type, "for", topic.Streamer.Username+", falling back:", err)
+			es.fallBack(topic, ids)
+			return
+		}
+		ids = append(ids, id)
+	}
+	es.subscriptions[topic] = ids
+	topic.State = TopicStateListening
+	topic.Error = ""
+
+	// we only get notified about changes, so check if the streamer is live right now
+	if topic.Topic == "video-playback-by-id" {
+		go es.pollViewers(topic.Streamer)
+	}
+}
+
+// fallBack moves the topic to the fallback transport and deletes the subscriptions already created for it. Must hold the lock
+func (es *EventSubConnection) fallBack(topic *WebsocketTopic, ids []string) {
+	for _, id := range ids {
+		if err := es.deleteSubscription(id); err != nil {
+			es.log("Error deleting subscription", id, err)
+		}
+	}
+	delete(es.subscriptions, topic)
+	if index := slices.Index(es.topics, topic); index != -1 {
+		es.topics = slices.Delete(es.topics, index, index+1)
+	}
+	es.fallenBack = append(es.fallenBack, topic)
+	topic.State = TopicStatePending
+	topic.Error = ""
+	if err := es.fallback.Listen(topic); err != nil {
+		es.log("Error listening to", topic.GetTopicName(), "on the fallback", err)
+	}
+}
+
+func (es *EventSubConnection) createSubscription(subscription eventSubSubscription) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"type":      subscription.Type,
+		"version":   subscription.Version,
+		"condition": subscription.Condition,
+		"transport": map[string]any{
+			"method":     "websocket",
+			"session_id": es.sessionID,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		Message string `json:"message"`
+	}
+	status, err := es.sendHelixRequest("POST", "/eventsub/subscriptions", body, &response)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusAccepted || len(response.Data) == 0 {
+		return "", fmt.Errorf("status %d: %s", status, response.Message)
+	}
+	return response.Data[0].ID, nil
+}
+
+func (es *EventSubConnection) deleteSubscription(id string) error {
+	status, err := es.sendHelixRequest("DELETE", "/eventsub/subscriptions?id="+id, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusNotFound {
+		return fmt.Errorf("failed to delete subscription %s: status %d", id, status)
+	}
+	return nil
+}
+
+func (es *EventSubConnection) sendHelixRequest(method string, path string, body []byte, ptr any) (int, error) {
+	request, err := http.NewRequest(method, es.helixURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+es.user.AuthToken)
+	request.Header.Set("Client-ID", defaultClientID)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", userAgent)
+
+	response, err := es.user.GraphQL.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode == http.StatusUnauthorized {
+		go es.user.authFailed("eventsub", ErrInvalidToken)
+	}
+
+	if ptr == nil {
+		_, err = io.Copy(io.Discard, response.Body)
+		return response.StatusCode, err
+	}
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(ptr)
+}
+
+// trackLive remembers which streamers are live, so their viewers can be polled
+func (es *EventSubConnection) trackLive(message *WebsocketMessage) {
+	if message.Topics[0] != "video-playback-by-id" {
+		return
+	}
+
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	for _, topic := range es.topics {
+		if topic.Topic == "video-playback-by-id" && topic.Streamer.ID == message.Topics[1] {
+			if message.Type == "stream-up" {
+				es.live[topic.Streamer] = true
+			} else {
+				delete(es.live, topic.Streamer)
+			}
+		}
+	}
+}
+
+// pollViewers fetches the current viewers of a streamer and emits them as viewcount, like PubSub would
+func (es *EventSubConnection) pollViewers(streamer *Streamer) {
+	es.lock.Lock()
+	user := es.user
+	es.lock.Unlock()
+
+	live, viewers, err := user.GraphQL.GetStreamViewers(streamer)
+	if err != nil {
+		es.log("Error polling viewers of", streamer.Username, err)
+		return
+	}
+
+	es.lock.Lock()
+	if live {
+		es.live[streamer] = true
+	} else {
+		delete(es.live, streamer)
+	}
+	es.lock.Unlock()
+
+	if !live {
+		return
+	}
+	data, err := json.Marshal(map[string]any{"type": "viewcount", "viewers": viewers})
+	if err != nil {
+		return
+	}
+	es.messages.send(WebsocketMessage{[]string{"video-playback-by-id", streamer.ID}, "viewcount", data})
+}
+
+// pollViewersForever keeps live streamers live, EventSub only tells us when a stream starts or ends
+func (es *EventSubConnection) pollViewersForever() {
+	for {
+		es.clock.Sleep(eventSubViewerPollInterval)
+
+		es.lock.Lock()
+		if es.closed {
+			es.lock.Unlock()
+			return
+		}
+		streamers := make([]*Streamer, 0, len(es.live))
+		for streamer := range es.live {
+			streamers = append(streamers, streamer)
+		}
+		es.lock.Unlock()
+
+		for _, streamer := range streamers {
+			es.pollViewers(streamer)
+		}
+	}
+}
+
+func (es *EventSubConnection) log(content ...any) {
+	content = append([]any{"[eventsub]"}, content...)
+	fmt.Println(content...)
+}
+
+// NewEventSubConnection subscribes with the token of the default user of the miner, topics it can not subscribe to are listened to on fallback.
+// Every new session uses the default user at that time.
+func NewEventSubConnection(miner *Miner, fallback Transport) *EventSubConnection {
+	endpoints := miner.GetOptions().Endpoints
+	es := &EventSubConnection{
+		url:           endpoints.EventSub,
+		helixURL:      endpoints.Helix,
+		miner:         miner,
+		user:          miner.GetDefaultUser(),
+		clock:         miner.Clock,
+		messages:      newMessageQueue(),
+		subscriptions: map[*WebsocketTopic][]string{},
+		live:          map[*Streamer]bool{},
+		fallback:      fallback,
+	}
+	go es.pollViewersForever()
+	return es
+}
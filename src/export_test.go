@@ -0,0 +1,39 @@
+package miner
+
+import "slices"
+
+// PendingRequests returns how many LISTENs and UNLISTENs of the pool wait for their RESPONSE
+func (pool *WebsocketPool) PendingRequests() int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pending := 0
+	for _, conn := range pool.connections {
+		pending += len(conn.pending)
+	}
+	return pending
+}
+
+// ConnectionTopics returns the topic names assigned to each connection of the pool
+func (pool *WebsocketPool) ConnectionTopics() [][]string {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	connections := [][]string{}
+	for _, conn := range pool.connections {
+		names := []string{}
+		for _, topic := range conn.topics {
+			names = append(names, topic.GetTopicName())
+		}
+		connections = append(connections, names)
+	}
+	return connections
+}
+
+// Connections returns the open connections of the pool
+func (pool *WebsocketPool) Connections() []*WebsocketConnection {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	return slices.Clone(pool.connections)
+}
@@ -50,17 +50,44 @@ func (gql *GraphQL) GetStreamBroadcastID(streamer *Streamer) error {
 	}
 
 	if res.Data.User != nil && res.Data.User.Stream != nil {
-		streamer.BroadcastID = res.Data.User.Stream.ID
+		streamer.SetBroadcastID(res.Data.User.Stream.ID)
 	}
 
 	return nil
 }
 
+// GetStreamViewers returns whether the streamer is live and how many viewers they have
+func (gql *GraphQL) GetStreamViewers(streamer *Streamer) (bool, int, error) {
+	req := GraphQLRequest{
+		OperationName: "VideoPlayerStreamInfoOverlayChannel",
+		Variables: map[string]any{
+			"channel": streamer.Username,
+		},
+		Extensions: GraphQLRequestExtensions{
+			PersistedQuery: GraphQLRequestExtensionsPersistedQuery{
+				Version:    1,
+				Sha256Hash: "a5f2e34d626a9f4f5c0204f910bab2194948a9502089be558bb6e779a9e1b3d2",
+			},
+		},
+	}
+
+	var res videoPlayerStreamInfoOverlayChannelResponse
+	if err := gql.SendRequest(req, &res); err != nil {
+		return false, 0, err
+	}
+
+	if res.Data.User == nil || res.Data.User.Stream == nil {
+		return false, 0, nil
+	}
+	return true, res.Data.User.Stream.ViewersCount, nil
+}
+
 type videoPlayerStreamInfoOverlayChannelResponse struct {
 	Data struct {
 		User *struct {
 			Stream *struct {
-				ID string `json:"id"`
+				ID           string `json:"id"`
+				ViewersCount int    `json:"viewersCount"`
 			} `json:"stream"`
 		} `json:"user"`
 	} `json:"data"`
@@ -17,12 +17,16 @@ func (gql *GraphQL) GetFollows() ([]string, error) {
 
 	follows := []string{}
 	cursor := ""
-	var res channelFollowsResponse
 	for {
+		var res channelFollowsResponse
 		req.Variables["cursor"] = cursor
 		if err := gql.SendRequest(req, &res); err != nil {
 			return nil, err
 		}
+		// a failed request has no data, which would look like no follows at all
+		if err := res.Errors.Err(); err != nil {
+			return nil, err
+		}
 
 		followsResponse := res.Data.User.Follows
 		for _, follow := range followsResponse.Edges {
@@ -39,7 +43,8 @@ func (gql *GraphQL) GetFollows() ([]string, error) {
 }
 
 type channelFollowsResponse struct {
-	Data struct {
+	Errors graphQLErrors `json:"errors"`
+	Data   struct {
 		User struct {
 			Follows struct {
 				Edges []struct {
@@ -20,9 +20,7 @@ func (gql *GraphQL) LoadChannelPoints(streamer *Streamer) error {
 	}
 
 	communityPoints := res.Data.Community.Channel.Self.CommunityPoints
-	gql.User.Miner.Lock.Lock()
-	streamer.Points[gql.User] = communityPoints.Balance
-	gql.User.Miner.Lock.Unlock()
+	streamer.SetPoints(gql.User, communityPoints.Balance, false)
 
 	if communityPoints.AvailableClaim != nil {
 		if err := gql.ClaimBonus(streamer, communityPoints.AvailableClaim.ID); err != nil {
@@ -68,5 +66,9 @@ func (gql *GraphQL) ClaimBonus(streamer *Streamer, claimID string) error {
 	}
 
 	var res any
-	return gql.SendRequest(req, &res)
+	if err := gql.SendRequest(req, &res); err != nil {
+		return err
+	}
+	gql.User.Miner.Events.Publish(ClaimCollected{gql.User, streamer, claimID})
+	return nil
 }
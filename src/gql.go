@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 const (
@@ -15,9 +17,18 @@ const (
 type GraphQL struct {
 	User          *User
 	ClientSession string
-	ClientVersion string
 	DeviceID      string
 	Client        *http.Client
+
+	clientVersion string
+	lock          sync.RWMutex
+}
+
+// SetClientVersion changes the Client-Version header of all future requests
+func (gql *GraphQL) SetClientVersion(version string) {
+	gql.lock.Lock()
+	defer gql.lock.Unlock()
+	gql.clientVersion = version
 }
 
 func (gql *GraphQL) SendRequest(payload GraphQLRequest, ptr any) error {
@@ -30,7 +41,7 @@ func (gql *GraphQL) SendRawRequest(payload any, ptr any) error {
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", "https://gql.twitch.tv/gql", bytes.NewReader(body))
+	request, err := http.NewRequest("POST", gql.User.Miner.GetOptions().Endpoints.GraphQL, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -39,13 +50,21 @@ func (gql *GraphQL) SendRawRequest(payload any, ptr any) error {
 	request.Header.Set("User-Agent", userAgent)
 	request.Header.Set("Client-ID", defaultClientID)
 	request.Header.Set("Client-Session-ID", gql.ClientSession)
-	request.Header.Set("Client-Version", gql.ClientVersion)
+	gql.lock.RLock()
+	request.Header.Set("Client-Version", gql.clientVersion)
+	gql.lock.RUnlock()
 	request.Header.Set("X-Device-ID", gql.DeviceID)
 
 	response, err := gql.Client.Do(request)
 	if err != nil {
 		return err
 	}
+	if response.StatusCode == http.StatusUnauthorized {
+		_ = response.Body.Close()
+		// the caller may hold locks the pause needs
+		go gql.User.authFailed("gql", ErrInvalidToken)
+		return ErrInvalidToken
+	}
 
 	err = json.NewDecoder(response.Body).Decode(ptr)
 	_ = response.Body.Close()
@@ -53,6 +72,23 @@ func (gql *GraphQL) SendRawRequest(payload any, ptr any) error {
 	return err
 }
 
+// graphQLErrors are returned instead of data when an operation fails, the status is still 200
+type graphQLErrors []struct {
+	Message string `json:"message"`
+}
+
+// Err returns the errors as a single error, or nil if there are none
+func (e graphQLErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Message)
+	}
+	return fmt.Errorf("graphql: %s", strings.Join(messages, ", "))
+}
+
 type GraphQLRequest struct {
 	OperationName string                   `json:"operationName"`
 	Variables     map[string]any           `json:"variables"`
@@ -76,7 +112,6 @@ func NewGraphQL(user *User) *GraphQL {
 	gql := &GraphQL{
 		User:          user,
 		ClientSession: clientSession,
-		ClientVersion: "",
 		DeviceID:      deviceID,
 		Client:        client,
 	}
@@ -0,0 +1,65 @@
+package miner_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+// startSimulator serves the script on random ports until the test ends
+func startSimulator(t *testing.T, script *simulator.Script) *simulator.Server {
+	t.Helper()
+	script, err := simulator.NewScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := simulator.NewServer(script)
+	if err := server.Start("127.0.0.1:0", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return server
+}
+
+// newTestMiner creates a miner against the simulator that listens through a MemoryTransport and has all users of the script added
+func newTestMiner(t *testing.T, server *simulator.Server, opts ...miner.Option) (*miner.Miner, *miner.MemoryTransport) {
+	t.Helper()
+	opts = append([]miner.Option{miner.WithEndpoints(server.Endpoints()), miner.WithPersistentFile("")}, opts...)
+	instance, err := miner.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := miner.NewMemoryTransport()
+	instance.Transport = transport
+	for _, user := range server.Script().Users {
+		if err := instance.AddUser(miner.NewUser(user.Name, user.Token)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return instance, transport
+}
+
+// assertListening fails the test unless exactly the topics are listened to, in any order
+func assertListening(t *testing.T, transport *miner.MemoryTransport, topics ...string) {
+	t.Helper()
+	listening := slices.Sorted(slices.Values(transport.Listening()))
+	topics = slices.Sorted(slices.Values(topics))
+	if !slices.Equal(listening, topics) {
+		t.Errorf("listening to %v, want %v", listening, topics)
+	}
+}
+
+// waitFor fails the test unless condition becomes true within 5 seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -17,12 +17,17 @@ func NewLoginSession(clientID ...string) *LoginSession {
 	}
 
 	return &LoginSession{
-		clientID: finalClientID,
-		deviceID: createRandomString(32),
+		Endpoints: DefaultEndpoints(),
+		Clock:     RealClock{},
+		clientID:  finalClientID,
+		deviceID:  createRandomString(32),
 	}
 }
 
 type LoginSession struct {
+	Endpoints Endpoints
+	Clock     Clock
+
 	clientID string
 	deviceID string
 
@@ -37,7 +42,7 @@ func (l *LoginSession) GetCode() (string, error) {
 		"scopes":    {"channel_read chat:read user_blocks_edit user_blocks_read user_follows_edit user_read"},
 	}
 
-	req, err := http.NewRequest("POST", "https://id.twitch.tv/oauth2/device", strings.NewReader(body.Encode()))
+	req, err := http.NewRequest("POST", l.Endpoints.ID+"/oauth2/device", strings.NewReader(body.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -59,13 +64,13 @@ func (l *LoginSession) GetCode() (string, error) {
 
 	l.deviceCode = response["device_code"].(string)
 	l.interval = time.Duration(response["interval"].(float64)) * time.Second
-	l.expiration = time.Now().Add(time.Duration(response["expires_in"].(float64)) * time.Second)
+	l.expiration = l.Clock.Now().Add(time.Duration(response["expires_in"].(float64)) * time.Second)
 
 	return response["user_code"].(string), nil
 }
 
 func (l *LoginSession) CheckCode() (string, error) {
-	if time.Now().After(l.expiration) {
+	if l.Clock.Now().After(l.expiration) {
 		return "", fmt.Errorf("device code expired")
 	}
 
@@ -75,7 +80,7 @@ func (l *LoginSession) CheckCode() (string, error) {
 		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
 	}
 
-	req, err := http.NewRequest("POST", "https://id.twitch.tv/oauth2/token", strings.NewReader(body.Encode()))
+	req, err := http.NewRequest("POST", l.Endpoints.ID+"/oauth2/token", strings.NewReader(body.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -110,8 +115,8 @@ func (l *LoginSession) CheckCode() (string, error) {
 }
 
 func (l *LoginSession) WaitForToken() (string, error) {
-	for time.Now().Before(l.expiration) {
-		time.Sleep(l.interval)
+	for l.Clock.Now().Before(l.expiration) {
+		l.Clock.Sleep(l.interval)
 		token, err := l.CheckCode()
 		if err != nil {
 			if err.Error() != "error: authorization_pending" {
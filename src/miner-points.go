@@ -31,20 +31,15 @@ func (miner *Miner) OnPointsUpdate(message WebsocketMessage) {
 		return
 	}
 
-	for _, user := range miner.Users {
+	gain := event.Data.PointGain
+	for _, user := range miner.GetUsers() {
 		if user.ID == userID {
-			miner.Lock.Lock()
-			streamer.Points[user] = balance.Balance
-			if event.Data.PointGain != nil && event.Data.PointGain.ReasonCode == "WATCH" {
-				streamer.GotPointsOnce[user] = true
+			streamer.SetPoints(user, balance.Balance, gain != nil && gain.ReasonCode == "WATCH")
+			if gain != nil {
+				miner.Events.Publish(PointsEarned{user, streamer, gain.TotalPoints, gain.ReasonCode, balance.Balance})
 			}
-			miner.Lock.Unlock()
 		}
 	}
-
-	if event.Data.PointGain != nil {
-		miner.Alert(fmt.Sprintf("+%d points from %s (%s)", event.Data.PointGain.TotalPoints, event.Data.PointGain.ReasonCode, streamer.Username))
-	}
 }
 
 type pointsUpdateEvent struct {
@@ -76,7 +71,7 @@ func (miner *Miner) OnClaimAvailable(message WebsocketMessage) {
 		return
 	}
 
-	for _, user := range miner.Users {
+	for _, user := range miner.GetUsers() {
 		if user.ID == userID {
 			if err := user.GraphQL.ClaimBonus(streamer, claim.ID); err != nil {
 				fmt.Println("Failed to claim bonus:", err)
@@ -102,41 +97,46 @@ const (
 	MiningStrategyMostViewers MiningStrategy = "MOST_VIEWERS"
 )
 
+var miningStrategies = []MiningStrategy{MiningStrategyLeastPoints, MiningStrategyMostPoints, MiningStrategyMostViewers}
+
 func (miner *Miner) MinePoints(user *User) error {
-	streamers := make([]*Streamer, 0, len(user.Streamers))
-	for _, streamer := range user.Streamers {
-		streamers = append(streamers, streamer)
+	streamers := user.GetStreamers()
+	// the options may be reloaded while mining, so all streamers are sorted by the same options
+	options := miner.GetOptions().ForUser(user.Username)
+	priority := map[string]int{}
+	for _, streamer := range streamers {
+		priority[streamer.Username] = options.Priority(streamer.Username)
 	}
 
 	slices.SortStableFunc(streamers, func(a, b *Streamer) int {
 		// prioritize streamers who havent been mined yet (to get the streak bonus)
-		if miner.Options.PrioritizeStreaks && a.GotPointsOnce[user] != b.GotPointsOnce[user] {
-			if a.GotPointsOnce[user] {
+		if options.PrioritizeStreaks && a.GotPointsOnce(user) != b.GotPointsOnce(user) {
+			if a.GotPointsOnce(user) {
 				return 1
 			}
 			return -1
 		}
-		aPrio := miner.Options.StreamerPriority[a.Username]
-		bPrio := miner.Options.StreamerPriority[b.Username]
+		aPrio := priority[a.Username]
+		bPrio := priority[b.Username]
 
 		if aPrio != bPrio {
 			return cmp.Compare(aPrio, bPrio)
 		}
 
-		switch miner.Options.MiningStrategy {
+		switch options.MiningStrategy {
 		case MiningStrategyMostViewers:
-			return cmp.Compare(b.Viewers, a.Viewers)
+			return cmp.Compare(b.Viewers(), a.Viewers())
 		case MiningStrategyMostPoints:
-			return cmp.Compare(b.Points[user], a.Points[user])
+			return cmp.Compare(b.Points(user), a.Points(user))
 		case MiningStrategyLeastPoints:
-			return cmp.Compare(a.Points[user], b.Points[user])
+			return cmp.Compare(a.Points(user), b.Points(user))
 		}
 		panic("invalid mining strategy")
 	})
 
 	for _, streamer := range streamers {
 		if streamer.IsLive() {
-			fmt.Printf("Streamer %s (%s): %d points, mined=%v\n", streamer.Username, streamer.ID, streamer.Points[user], streamer.GotPointsOnce[user])
+			fmt.Printf("Streamer %s (%s): %d points, mined=%v\n", streamer.Username, streamer.ID, streamer.Points(user), streamer.GotPointsOnce(user))
 		}
 	}
 
@@ -144,11 +144,11 @@ func (miner *Miner) MinePoints(user *User) error {
 	watched := 0
 	for _, streamer := range streamers {
 		// no points => points disabled usually. TODO: better check if points are disabled
-		if !streamer.IsLive() {
+		if !streamer.IsLive() || !options.ForStreamer(streamer.Username).MinePoints {
 			continue
 		}
-		if miner.Options.ConcurrentPointLimit < 0 || mined < miner.Options.ConcurrentPointLimit {
-			if streamer.Points[user] == 0 {
+		if options.ConcurrentPointLimit < 0 || mined < options.ConcurrentPointLimit {
+			if streamer.Points(user) == 0 {
 				continue
 			}
 			if err := miner.minePoints(streamer, user); err != nil {
@@ -156,7 +156,7 @@ func (miner *Miner) MinePoints(user *User) error {
 				continue
 			}
 			mined++
-		} else if miner.Options.ConcurrentWatchLimit < 0 || watched < miner.Options.ConcurrentWatchLimit {
+		} else if options.ConcurrentWatchLimit < 0 || watched < options.ConcurrentWatchLimit {
 			if err := miner.minePointsPlayback(streamer, user); err != nil {
 				fmt.Println("Error mining points for", streamer.Username, ":", err)
 				continue
@@ -171,7 +171,7 @@ func (miner *Miner) MinePoints(user *User) error {
 }
 
 func (miner *Miner) minePoints(streamer *Streamer, user *User) error {
-	fmt.Println("Mining points for", streamer.Username, streamer.ID, "mined=", streamer.GotPointsOnce[user], "points=", streamer.Points[user])
+	fmt.Println("Mining points for", streamer.Username, streamer.ID, "mined=", streamer.GotPointsOnce(user), "points=", streamer.Points(user))
 	if err := miner.minePointsPlayback(streamer, user); err != nil {
 		return fmt.Errorf("failed to mine points on playback: %w", err)
 	}
@@ -189,7 +189,7 @@ func (miner *Miner) minePointsPlayback(streamer *Streamer, user *User) error {
 		return fmt.Errorf("failed to get playback access token: %w", err)
 	}
 
-	requestBroadcastQualitiesURL := fmt.Sprintf("https://usher.ttvnw.net/api/channel/hls/%s.m3u8?sig=%s&token=%s", streamer.Username, signature, value)
+	requestBroadcastQualitiesURL := fmt.Sprintf("%s/api/channel/hls/%s.m3u8?sig=%s&token=%s", miner.GetOptions().Endpoints.Usher, streamer.Username, signature, value)
 	request, err := http.NewRequest("GET", requestBroadcastQualitiesURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -267,11 +267,13 @@ func (miner *Miner) minePointsPlayback(streamer *Streamer, user *User) error {
 }
 
 func (miner *Miner) minePointsSpade(streamer *Streamer, user *User) error {
-	if streamer.BroadcastID == "" {
+	broadcastID := streamer.BroadcastID()
+	if broadcastID == "" {
 		fmt.Println("Don't have broadcast id, getting it")
 		if err := user.GraphQL.GetStreamBroadcastID(streamer); err != nil {
 			return fmt.Errorf("failed to get broadcast id: %w", err)
 		}
+		broadcastID = streamer.BroadcastID()
 	}
 
 	payload := []map[string]any{
@@ -279,7 +281,7 @@ func (miner *Miner) minePointsSpade(streamer *Streamer, user *User) error {
 			"event": "minute-watched",
 			"properties": map[string]any{
 				"channel_id":   streamer.ID,
-				"broadcast_id": streamer.BroadcastID,
+				"broadcast_id": broadcastID,
 				"player":       "site",
 				"user_id":      user.ID,
 				"live":         true,
@@ -333,7 +335,7 @@ func (miner *Miner) submitLegacySpade(user *User, payload []byte) error {
 	}
 	body := strings.NewReader(data.Encode())
 
-	request, err := http.NewRequest("POST", miner.SpadeUrl, body)
+	request, err := http.NewRequest("POST", miner.SpadeURL(), body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
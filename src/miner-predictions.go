@@ -1,11 +1,14 @@
 package miner
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"math/rand"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,67 +19,107 @@ func (miner *Miner) OnPredictionUpdate(message WebsocketMessage) {
 		return
 	}
 
-	miner.Lock.Lock()
-	defer miner.Lock.Unlock()
+	model := &event.Data.Event
+	if model.Status == PredictionStatusResolved {
+		miner.Lock.Lock()
+		delete(miner.Predictions, model.ID)
+		miner.Lock.Unlock()
 
-	if event.Data.Event.Status == PredictionStatusResolved {
-		delete(miner.Predictions, event.Data.Event.ID)
-		fmt.Println("Prediction resolved", event.Data.Event.ID)
-		if event.Data.Event.WinningOutcomeID != nil {
+		fmt.Println("Prediction resolved", model.ID)
+		if model.WinningOutcomeID != nil {
 			winner := ""
-			for _, outcome := range event.Data.Event.Outcomes {
-				if outcome.ID == *event.Data.Event.WinningOutcomeID {
+			for _, outcome := range model.Outcomes {
+				if outcome.ID == *model.WinningOutcomeID {
 					winner = outcome.Title
 					break
 				}
 			}
 
 			if winner != "" {
-				data, ok := miner.Persistent.PredictionResults[event.Data.Event.PredictionID()]
-				if !ok {
-					data = map[string]int{}
-					miner.Persistent.PredictionResults[event.Data.Event.PredictionID()] = data
-				}
-				data[winner]++
-
-				if err := miner.Persistent.Save(miner.Options); err != nil {
-					fmt.Println("Failed to save prediction results", err)
-				}
+				miner.Events.Publish(PredictionResolved{
+					Streamer:     miner.GetStreamerByID(model.ChannelID),
+					PredictionID: model.ID,
+					Title:        model.Title,
+					Winner:       winner,
+					key:          model.PredictionID(),
+				})
 			}
 		}
 
 		return
 	}
 
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+
 	pred, ok := miner.Predictions[event.Data.Event.ID]
 	if !ok {
-		pred = &Prediction{
-			Event: &event.Data.Event,
-			Miner: miner,
-		}
+		pred = &Prediction{Miner: miner}
 		miner.Predictions[event.Data.Event.ID] = pred
 	}
 
-	pred.Event = &event.Data.Event
-
-	if pred.Event.Status == PredictionStatusActive && !pred.Bet && !pred.BetPending {
-		pred.BetPending = true
-		go pred.DelayedBet()
+	if pred.update(model) {
+		miner.inflight.Add(1)
+		go func() {
+			defer miner.inflight.Done()
+			pred.DelayedBet(miner.ctx)
+		}()
 	}
 }
 
+// Prediction is updated by the transport while a bet is pending, so its state is only accessible through methods.
+// Events are never modified once received, every update replaces the whole event.
 type Prediction struct {
-	Event      *predictionModel
-	Miner      *Miner
-	Bet        bool
-	BetPending bool
+	Miner *Miner
+
+	event      *predictionModel
+	bet        bool
+	betPending bool
+	lock       sync.Mutex
+}
+
+// Event returns the latest state of the prediction
+func (p *Prediction) Event() *predictionModel {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.event
+}
+
+// HasBet returns whether a bet was placed on the prediction
+func (p *Prediction) HasBet() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.bet
 }
 
-func (p *Prediction) DelayedBet() {
+// update replaces the event and returns whether a bet should be scheduled
+func (p *Prediction) update(event *predictionModel) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.event = event
+	if event.Status == PredictionStatusActive && !p.bet && !p.betPending {
+		p.betPending = true
+		return true
+	}
+	return false
+}
+
+// DelayedBet bets shortly before the prediction locks, unless ctx is cancelled first
+func (p *Prediction) DelayedBet(ctx context.Context) {
 	// sleep until 5s before the end of the prediction
 	// this is to avoid the prediction being locked before we can place a bet
-	time.Sleep(time.Until(p.Event.CreatedAt.Add(time.Duration(p.Event.PredictionWindowSeconds-5) * time.Second)))
-	if p.Event.Status != PredictionStatusActive {
+	event := p.Event()
+	clock := p.Miner.Clock
+	timer := clock.NewTimer(clock.Until(event.CreatedAt.Add(time.Duration(event.PredictionWindowSeconds-5) * time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		fmt.Println("Not betting on", event.Title, "because the miner is stopping")
+		return
+	case <-timer.C():
+	}
+	if p.Event().Status != PredictionStatusActive {
 		// welp, prediction is no longer active
 		// can be caused by early resolve
 		return
@@ -95,26 +138,71 @@ const (
 	PredictionStrategyCautious             PredictionStrategy = "CAUTIOUS"
 )
 
+var predictionStrategies = []PredictionStrategy{PredictionStrategyRandom, PredictionStrategyMostPoints, PredictionStrategyMostIndividuals, PredictionStrategyMostIndividualPoints, PredictionStrategyCautious}
+
 var (
 	insymGhostGambling = regexp.MustCompile(`Will it be a [^ ]+ or a Mimic?`)
 )
 
 func (p *Prediction) SmartBet() {
-	var bet predictionOutcome
+	event := p.Event()
+	streamer := p.Miner.GetStreamerByID(event.ChannelID)
+	if len(event.Outcomes) == 0 || streamer == nil {
+		return
+	}
+
+	// users that stopped mining the streamer while the bet was pending are left out
+	for _, user := range p.Miner.GetUsersForStreamer(streamer.ID) {
+		options := p.Miner.GetOptions().ForUser(user.Username).ForStreamer(streamer.Username)
+		if !options.MinePredictions {
+			continue
+		}
+		bet, betAmount := p.chooseBet(event, options)
+		if bet.ID == "" {
+			continue
+		}
 
+		points := streamer.Points(user)
+		userBet := betAmount
+		if userBet > points {
+			userBet = points
+		}
+		if pointsLeft := points - userBet; pointsLeft < options.PredictionsMinPoints {
+			userBet = points - options.PredictionsMinPoints
+		}
+		if userBet < 10 {
+			continue
+		}
+
+		fmt.Printf("Betting %d points on %s (%s) for %s\n", userBet, bet.Title, bet.ID, event.Title)
+		if err := user.GraphQL.MakePrediction(event.ID, bet.ID, userBet); err != nil {
+			fmt.Println("Failed to place bet", err)
+			continue
+		}
+		p.Miner.Events.Publish(BetPlaced{user, streamer, event.ID, event.Title, bet.ID, bet.Title, userBet})
+	}
+
+	p.lock.Lock()
+	p.bet = true
+	p.lock.Unlock()
+}
+
+// chooseBet returns the outcome to bet on and how much to bet at most, the outcome has no ID if nothing should be bet
+func (p *Prediction) chooseBet(event *predictionModel, options Options) (predictionOutcome, int) {
+	var bet predictionOutcome
 	totalPointsBet := 0
-	for _, outcome := range p.Event.Outcomes {
+	for _, outcome := range event.Outcomes {
 		totalPointsBet += outcome.TotalPoints
 	}
 
-	betAmount := p.Miner.Options.PredictionsMaxBet
+	betAmount := options.PredictionsMaxBet
 
-	switch p.Miner.Options.PredictionsStrategy {
+	switch options.PredictionsStrategy {
 	case PredictionStrategyRandom:
-		bet = p.Event.Outcomes[rand.Intn(len(p.Event.Outcomes))]
+		bet = event.Outcomes[rand.Intn(len(event.Outcomes))]
 	case PredictionStrategyMostPoints:
 		mostPoints := 0
-		for _, outcome := range p.Event.Outcomes {
+		for _, outcome := range event.Outcomes {
 			if outcome.TotalPoints > mostPoints {
 				bet = outcome
 				mostPoints = outcome.TotalPoints
@@ -122,7 +210,7 @@ func (p *Prediction) SmartBet() {
 		}
 	case PredictionStrategyMostIndividuals:
 		mostIndividuals := 0
-		for _, outcome := range p.Event.Outcomes {
+		for _, outcome := range event.Outcomes {
 			if outcome.TotalUsers > mostIndividuals {
 				bet = outcome
 				mostIndividuals = outcome.TotalUsers
@@ -130,7 +218,7 @@ func (p *Prediction) SmartBet() {
 		}
 	case PredictionStrategyMostIndividualPoints:
 		mostIndividualPoints := 0
-		for _, outcome := range p.Event.Outcomes {
+		for _, outcome := range event.Outcomes {
 			for _, better := range outcome.TopPredictors {
 				if better.Points > mostIndividualPoints {
 					bet = outcome
@@ -139,8 +227,11 @@ func (p *Prediction) SmartBet() {
 			}
 		}
 	case PredictionStrategyCautious:
-		data, ok := p.Miner.Persistent.PredictionResults[p.Event.PredictionID()]
-		if p.Event.ChannelID == "75738685" && insymGhostGambling.Match([]byte(p.Event.Title)) { // insym
+		p.Miner.Lock.Lock()
+		data, ok := p.Miner.Persistent.PredictionResults[event.PredictionID()]
+		data = maps.Clone(data)
+		p.Miner.Lock.Unlock()
+		if event.ChannelID == "75738685" && insymGhostGambling.Match([]byte(event.Title)) { // insym
 			// odds are 1:12
 			data = map[string]int{
 				"Yes": 1,
@@ -155,14 +246,14 @@ func (p *Prediction) SmartBet() {
 				total += outcome
 			}
 
-			if total > p.Miner.Options.PredictionsDataPoints {
+			if total > options.PredictionsDataPoints {
 				// we have enough data to make a bet
 				// we check which option has the highest Return on Investment
 				// eg if an option wins 1/3 of the time but only has 25% odds, we bet on it
 				// since it's a 1/3 chance to win 4x our bet
 				bestROI := 0.0
 				pointDiff := 0
-				for _, outcome := range p.Event.Outcomes {
+				for _, outcome := range event.Outcomes {
 					timesWon := data[outcome.Title]
 					expectedWinrate := float64(timesWon) / float64(total)
 					currentWinrate := float64(outcome.TotalPoints) / float64(totalPointsBet)
@@ -185,14 +276,14 @@ func (p *Prediction) SmartBet() {
 	}
 
 	if bet.ID == "" {
-		return
+		return bet, 0
 	}
 
-	if betAmount > totalPointsBet*p.Miner.Options.PredictionsMaxRatio {
-		betAmount = totalPointsBet * p.Miner.Options.PredictionsMaxRatio
+	if betAmount > totalPointsBet*options.PredictionsMaxRatio {
+		betAmount = totalPointsBet * options.PredictionsMaxRatio
 	}
 
-	if p.Miner.Options.PredictionsStealth {
+	if options.PredictionsStealth {
 		// dont bet more than the highest individual bet
 		// the highest individual bet is displayed publicly to everyone
 		highestIndividualBet := 0
@@ -207,29 +298,7 @@ func (p *Prediction) SmartBet() {
 		}
 	}
 
-	streamer := p.Miner.GetStreamerByID(p.Event.ChannelID)
-
-	for _, user := range p.Miner.Users {
-		userBet := betAmount
-		if userBet > streamer.Points[user] {
-			userBet = streamer.Points[user]
-		}
-		if pointsLeft := streamer.Points[user] - userBet; pointsLeft < p.Miner.Options.PredictionsMinPoints {
-			userBet = streamer.Points[user] - p.Miner.Options.PredictionsMinPoints
-		}
-		if userBet < 10 {
-			continue
-		}
-
-		fmt.Printf("Betting %d points on %s (%s) for %s\n", userBet, bet.Title, bet.ID, p.Event.Title)
-		p.Miner.Alert(fmt.Sprintf("Betting %d points on %s (%s) for %s\n", userBet, bet.Title, bet.ID, p.Event.Title))
-		if err := user.GraphQL.MakePrediction(p.Event.ID, bet.ID, userBet); err != nil {
-			fmt.Println("Failed to place bet", err)
-			continue
-		}
-	}
-
-	p.Bet = true
+	return bet, betAmount
 }
 
 func (p *predictionModel) PredictionID() string {
@@ -252,6 +321,7 @@ const (
 	PredictionStatusLocked         PredictionStatus = "LOCKED"
 	PredictionStatusResolvePending PredictionStatus = "RESOLVE_PENDING"
 	PredictionStatusResolved       PredictionStatus = "RESOLVED"
+	PredictionStatusCanceled       PredictionStatus = "CANCELED"
 )
 
 type predictionEvent struct {
@@ -273,8 +343,8 @@ type predictionModel struct {
 	Outcomes                []predictionOutcome `json:"outcomes"`
 }
 
-func (p *predictionModel) CanBet() bool {
-	return p.Status == PredictionStatusActive && p.LockedAt.IsZero() && time.Since(p.CreatedAt) < time.Duration(p.PredictionWindowSeconds)*time.Second
+func (p *predictionModel) CanBet(now time.Time) bool {
+	return p.Status == PredictionStatusActive && p.LockedAt.IsZero() && now.Sub(p.CreatedAt) < time.Duration(p.PredictionWindowSeconds)*time.Second
 }
 
 type predictionOutcome struct {
@@ -0,0 +1,86 @@
+package miner_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+func TestPredictionBetsBeforeLock(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer", Points: 10_000}},
+	})
+	clock := miner.NewFakeClock(start)
+	instance, transport := newTestMiner(t, server, miner.WithClock(clock), func(o *miner.Options) {
+		o.MineWatchtime = false
+		o.PredictionsStrategy = miner.PredictionStrategyMostPoints
+	})
+	streamer, err := instance.AddStreamer("streamer", instance.GetDefaultUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bets := make(chan miner.BetPlaced, 1)
+	instance.Events.Subscribe(func(event miner.Event) {
+		if bet, ok := event.(miner.BetPlaced); ok {
+			bets <- bet
+		}
+	})
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = instance.Stop() })
+
+	streamer.StreamUp()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+
+	// the simulator only accepts bets on predictions it created
+	err = server.Emit(simulator.Event{
+		Type: simulator.EventPredictionCreated, Channel: "streamer", ID: "prediction",
+		Title: "Will we win?", Outcomes: []string{"Yes", "No"}, OutcomePoints: []int{40_000, 10_000}, Window: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]any{
+		"type": "event-created",
+		"data": map[string]any{"event": map[string]any{
+			"id":                        "prediction",
+			"channel_id":                streamer.ID,
+			"created_at":                clock.Now(),
+			"prediction_window_seconds": 60,
+			"status":                    miner.PredictionStatusActive,
+			"title":                     "Will we win?",
+			"outcomes": []map[string]any{
+				{"id": "prediction-outcome-0", "title": "Yes", "total_points": 40_000, "total_users": 40},
+				{"id": "prediction-outcome-1", "title": "No", "total_points": 10_000, "total_users": 10},
+			},
+		}},
+	})
+	message := miner.WebsocketMessage{Topics: []string{"predictions-channel-v1", streamer.ID}, Type: "event-created", Data: data}
+	if !transport.Publish(message) {
+		t.Fatal("predictions of the live streamer are not listened to")
+	}
+
+	// the bet is placed 5 seconds before the prediction locks
+	clock.Advance(54 * time.Second)
+	select {
+	case bet := <-bets:
+		t.Fatalf("bet %d points on %s too early", bet.Points, bet.Outcome)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case bet := <-bets:
+		if bet.Outcome != "Yes" {
+			t.Errorf("bet on %s, want Yes", bet.Outcome)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no bet was placed")
+	}
+}
@@ -12,12 +12,27 @@ func (miner *Miner) OnRaidUpdate(message WebsocketMessage) {
 		return
 	}
 
+	if event.Raid.ID == "" {
+		// EventSub only tells us about raids once they happened, there is nothing to join
+		return
+	}
+
+	streamer := miner.GetStreamerByID(event.Raid.SourceID)
+	if streamer == nil {
+		return
+	}
 	users := miner.GetUsersForStreamer(event.Raid.SourceID)
 
+	options := miner.GetOptions()
 	for _, user := range users {
+		if !options.ForUser(user.Username).ForStreamer(streamer.Username).MineRaids {
+			continue
+		}
 		if err := user.GraphQL.JoinRaid(event.Raid.ID); err != nil {
 			fmt.Println("Failed to join raid:", err)
+			continue
 		}
+		miner.Events.Publish(RaidJoined{user, streamer, event.Raid.ID})
 	}
 }
 
@@ -3,7 +3,6 @@ package miner
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 )
 
 func (miner *Miner) OnStreamUp(message WebsocketMessage) {
@@ -13,11 +12,8 @@ func (miner *Miner) OnStreamUp(message WebsocketMessage) {
 		return
 	}
 
-	streamer.LastLivePing = time.Now()
-	streamer.Viewers = 0
-	for u := range streamer.GotPointsOnce {
-		delete(streamer.GotPointsOnce, u)
-	}
+	streamer.StreamUp()
+	miner.Events.Publish(StreamUp{streamer})
 }
 
 func (miner *Miner) OnStreamDown(message WebsocketMessage) {
@@ -27,9 +23,8 @@ func (miner *Miner) OnStreamDown(message WebsocketMessage) {
 		return
 	}
 
-	streamer.LastLivePing = time.Time{}
-	streamer.BroadcastID = ""
-	streamer.Viewers = 0
+	streamer.StreamDown()
+	miner.Events.Publish(StreamDown{streamer})
 }
 
 func (miner *Miner) OnViewcount(message WebsocketMessage) {
@@ -44,8 +39,7 @@ func (miner *Miner) OnViewcount(message WebsocketMessage) {
 		return
 	}
 
-	streamer.LastLivePing = time.Now()
-	streamer.Viewers = event.Viewers
+	streamer.UpdateViewers(event.Viewers)
 }
 
 type viewcountEvent struct {
@@ -1,17 +1,30 @@
 package miner
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
+// how long the miner waits for pending claims and bets when stopping.
+// Docker kills containers 10s after asking them to stop by default
+const shutdownTimeout = 8 * time.Second
+
 type Miner struct {
-	Options       Options
-	WebsocketPool *WebsocketPool
+	// Options can be changed with UpdateOptions while the miner is running, read them with GetOptions
+	Options   Options
+	Clock     Clock
+	Transport Transport
+	Recorder  *Recorder
+	Events    *EventBus
 
 	DefaultUser *User
 	Users       map[string]*User
@@ -19,70 +32,288 @@ type Miner struct {
 	Predictions map[string]*Prediction
 	Persistent  *PersistentState
 
-	SpadeUrl string
+	spadeURL      string
+	clientVersion string
+	// authErrors are why Twitch rejected the token of a user by name, the user is paused until it is added again with a working token
+	authErrors map[string]error
 
 	PrometheusExporter *PrometheusExporter
+	Scheduler          *Scheduler
+	// eventSub is set once the miner started with the eventsub transport, it follows the DefaultUser
+	eventSub *EventSubConnection
+
+	// ctx is cancelled when the miner is stopping, inflight tracks claims and bets in progress
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inflight sync.WaitGroup
+	// stopped is closed once the miner shut down, stopErr holds the errors of the shutdown
+	stopped chan struct{}
+	stopErr error
 
-	Lock sync.Mutex
+	// Lock guards DefaultUser, Users, Streamers, Predictions, Persistent, authErrors, eventSub and the Streamers of every user
+	Lock        sync.Mutex
+	optionsLock sync.RWMutex
 }
 
-func (miner *Miner) AddUser(user *User) {
+// New creates a miner with the DefaultOptions changed by opts.
+// Users and streamers can be added before and after Start.
+func New(opts ...Option) (*Miner, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return NewMiner(options), nil
+}
+
+// AddUser adds a user to mine with. Users added while the miner is running are listened to right away
+func (miner *Miner) AddUser(user *User) error {
 	user.Miner = miner
 
+	// the token tells who the user is, a mistyped name or expired token would otherwise only show as missing points
+	info, err := user.ValidateToken()
+	if err != nil {
+		return fmt.Errorf("failed to validate token of user %s: %w", cmp.Or(user.Username, "without name"), err)
+	}
+	if err := miner.GetOptions().checkToken(user.Username, info); err != nil {
+		return fmt.Errorf("user %s: %w", cmp.Or(user.Username, info.Login), err)
+	}
+	if user.Username == "" {
+		user.Username = info.Login
+	}
+	user.ID = info.UserID
+
+	miner.Lock.Lock()
+	if _, ok := miner.Users[user.Username]; ok {
+		miner.Lock.Unlock()
+		return fmt.Errorf("user %s was already added", user.Username)
+	}
 	miner.Users[user.Username] = user
 	if miner.DefaultUser == nil {
 		miner.DefaultUser = user
 	}
+	user.GraphQL.SetClientVersion(miner.clientVersion)
+	running := miner.running()
+	// the token was just validated, so a paused user is back
+	_, restored := miner.authErrors[user.Username]
+	delete(miner.authErrors, user.Username)
+	eventSub := miner.eventSub
+	miner.Lock.Unlock()
 
-	if user.ID == "" {
-		user.ID, _ = user.GraphQL.GetSteamerID(user.Username)
+	if restored {
+		fmt.Println("Resuming user", user.Username, "with the new token")
+		miner.Events.Publish(AuthRestored{User: user})
+	}
+	if eventSub != nil {
+		// the user may be the new default user, e.g. when its paused predecessor was removed
+		go eventSub.useDefaultUser()
 	}
+
+	if running {
+		if err := miner.Transport.Listen(miner.userTopics(user)...); err != nil {
+			fmt.Println("Error listening to topics of", user.Username, err)
+		}
+		if miner.GetOptions().ForUser(user.Username).AnyStreamer(func(o Options) bool { return o.MineWatchtime }) {
+			user.ConnectToChat()
+		}
+	}
+	return nil
 }
 
+// GetOptions returns a snapshot of the current options
+func (miner *Miner) GetOptions() Options {
+	miner.optionsLock.RLock()
+	defer miner.optionsLock.RUnlock()
+	return miner.Options
+}
+
+// UpdateOptions replaces the options of the running miner and logs what changed.
+// The options are kept if the new ones are invalid or change options that require a restart.
+// Topics and chats are updated to what the new options need.
+func (miner *Miner) UpdateOptions(options Options) error {
+	options.Endpoints = options.Endpoints.WithDefaults()
+
+	miner.Lock.Lock()
+	started := miner.cancel != nil
+	miner.Lock.Unlock()
+
+	miner.optionsLock.Lock()
+	changes := diffOptions(miner.Options, options)
+	if len(changes) == 0 {
+		miner.optionsLock.Unlock()
+		return nil
+	}
+	restart := []string{}
+	for _, change := range changes {
+		fmt.Println(change)
+		if !slices.Contains(reloadableOptions, change.Name) {
+			restart = append(restart, change.Name)
+		}
+	}
+	if err := options.Validate(); err != nil {
+		miner.optionsLock.Unlock()
+		return err
+	}
+	if len(restart) > 0 {
+		miner.optionsLock.Unlock()
+		return fmt.Errorf("changing %s requires a restart", strings.Join(restart, ", "))
+	}
+	// tasks are only added on start, a feature nobody used before has no task running it
+	if started {
+		for _, task := range slices.Sorted(maps.Keys(taskFeatures)) {
+			if !miner.Options.Any(taskFeatures[task]) && options.Any(taskFeatures[task]) {
+				restart = append(restart, task)
+			}
+		}
+	}
+	if len(restart) > 0 {
+		miner.optionsLock.Unlock()
+		return fmt.Errorf("the %s tasks are not running, enabling them requires a restart", strings.Join(restart, ", "))
+	}
+	miner.Options = options
+	miner.optionsLock.Unlock()
+
+	if started {
+		miner.updateTopics()
+		for _, user := range miner.GetUsers() {
+			if options.ForUser(user.Username).AnyStreamer(func(o Options) bool { return o.MineWatchtime }) {
+				user.ConnectToChat()
+			}
+		}
+	}
+	return nil
+}
+
+// GetDefaultUser returns the user used for requests that do not belong to a specific user
+func (miner *Miner) GetDefaultUser() *User {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+	return miner.DefaultUser
+}
+
+// GetUsers returns a snapshot of all users
+func (miner *Miner) GetUsers() []*User {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+	return slices.Collect(maps.Values(miner.Users))
+}
+
+// GetStreamers returns a snapshot of all streamers
+func (miner *Miner) GetStreamers() []*Streamer {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+	return slices.Collect(maps.Values(miner.Streamers))
+}
+
+// AddStreamersFromFollows adds the streamers the user follows, except the excluded ones
 func (miner *Miner) AddStreamersFromFollows(user *User) error {
+	follows, err := miner.getFollows(user)
+	if err != nil {
+		return err
+	}
+
+	return miner.BulkAddStreamers(user, follows)
+}
+
+// getFollows returns the streamers the user follows that are not excluded for the user
+func (miner *Miner) getFollows(user *User) ([]string, error) {
+	follows, err := user.GraphQL.GetFollows()
+	if err != nil {
+		return nil, err
+	}
+	options := miner.GetOptions().ForUser(user.Username)
+	return slices.DeleteFunc(follows, options.Excluded), nil
+}
+
+// SyncFollows adds the streamers the user started following and removes the ones the user unfollowed.
+// Streamers with a priority are pinned and never removed.
+func (miner *Miner) SyncFollows(user *User) error {
 	follows, err := user.GraphQL.GetFollows()
 	if err != nil {
 		return err
 	}
+	options := miner.GetOptions().ForUser(user.Username)
+	following := len(follows)
+	follows = slices.DeleteFunc(follows, options.Excluded)
 
-	miner.BulkAddStreamers(user, follows)
-	return nil
+	miner.Lock.Lock()
+	added := []string{}
+	for _, username := range follows {
+		if _, ok := user.Streamers[username]; !ok {
+			added = append(added, username)
+		}
+	}
+	removed := []string{}
+	for username := range user.Streamers {
+		if !options.Pinned(username) && !slices.Contains(follows, username) {
+			removed = append(removed, username)
+		}
+	}
+	miner.Lock.Unlock()
+
+	// Twitch sometimes answers with no follows at all, which would remove every streamer of the user
+	if following == 0 && len(removed) > 0 {
+		return fmt.Errorf("got no follows for %s, keeping its %d streamers", user.Username, len(removed))
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	fmt.Println("Follows of", user.Username, "changed, adding", added, "and removing", removed)
+
+	errs := []error{miner.BulkAddStreamers(user, added)}
+	for _, username := range removed {
+		errs = append(errs, miner.RemoveStreamer(user, username))
+	}
+
+	// replays need to know the IDs of the new streamers
+	if miner.Recorder != nil {
+		errs = append(errs, miner.Recorder.RecordAccounts(miner))
+	}
+	return errors.Join(errs...)
 }
 
-func (miner *Miner) BulkAddStreamers(user *User, streamers []string) {
+// BulkAddStreamers adds all streamers concurrently, streamers that could not be added are skipped
+func (miner *Miner) BulkAddStreamers(user *User, streamers []string) error {
 	wg := sync.WaitGroup{}
-	for _, streamer := range streamers {
+	errs := make([]error, len(streamers))
+	for i, streamer := range streamers {
 		wg.Add(1)
-		go func(streamer string) {
+		go func() {
 			defer wg.Done()
-			miner.AddStreamer(streamer, user)
-		}(streamer)
+			_, errs[i] = miner.AddStreamer(streamer, user)
+		}()
 	}
 
 	wg.Wait()
+	return errors.Join(errs...)
 }
 
-func (miner *Miner) AddStreamer(username string, user *User) *Streamer {
-	var streamer *Streamer
+// AddStreamer adds a streamer to mine for the user. Streamers added while the miner is running are listened to right away
+func (miner *Miner) AddStreamer(username string, user *User) (*Streamer, error) {
+	miner.Lock.Lock()
+	streamer, ok := miner.Streamers[username]
+	miner.Lock.Unlock()
 
-	if existing, ok := miner.Streamers[username]; ok {
-		streamer = existing
-	} else {
+	if !ok {
 		id, err := user.GraphQL.GetSteamerID(username)
 		if err != nil {
-			// TODO: make this return an error
-			panic(err)
+			return nil, fmt.Errorf("failed to get id of streamer %s: %w", username, err)
 		} else if id == "" {
-			fmt.Println("Could not find streamer ID for", username)
-			return nil
+			return nil, fmt.Errorf("streamer %s not found", username)
 		}
 
-		streamer = &Streamer{
-			Username:      username,
-			ID:            id,
-			Points:        map[*User]int{},
-			GotPointsOnce: map[*User]bool{},
+		// another user may have added the streamer in the meantime
+		miner.Lock.Lock()
+		if existing, ok := miner.Streamers[username]; ok {
+			streamer = existing
+		} else {
+			streamer = NewStreamer(username, id, miner.Clock)
+			miner.Streamers[username] = streamer
 		}
+		miner.Lock.Unlock()
 	}
 
 	if err := user.GraphQL.LoadChannelPoints(streamer); err != nil {
@@ -90,16 +321,139 @@ func (miner *Miner) AddStreamer(username string, user *User) *Streamer {
 	}
 
 	miner.Lock.Lock()
-	defer miner.Lock.Unlock()
-
-	miner.Streamers[username] = streamer
 	if _, ok := user.Streamers[username]; !ok {
 		user.Streamers[username] = streamer
 	}
-	return streamer
+	running := miner.running()
+	miner.Lock.Unlock()
+
+	if running {
+		if err := miner.Transport.Listen(miner.streamerTopics(streamer)...); err != nil {
+			fmt.Println("Error listening to topics of", username, err)
+		}
+	}
+	return streamer, nil
+}
+
+// RemoveStreamer stops mining the streamer for the user.
+// Once no user mines the streamer anymore, its topics are unlistened and its predictions dropped.
+func (miner *Miner) RemoveStreamer(user *User, username string) error {
+	miner.Lock.Lock()
+	streamer, ok := user.Streamers[username]
+	if !ok {
+		miner.Lock.Unlock()
+		return fmt.Errorf("user %s does not mine streamer %s", user.Username, username)
+	}
+	delete(user.Streamers, username)
+	orphaned := miner.orphanStreamers([]*Streamer{streamer})
+	miner.Lock.Unlock()
+
+	streamer.forget(user)
+	if chat := user.GetChat(); chat != nil {
+		chat.Leave(streamer.ChannelName())
+	}
+	if miner.PrometheusExporter != nil {
+		miner.PrometheusExporter.DeleteUserStreamer(user, streamer)
+	}
+	return miner.removeStreamers(orphaned)
+}
+
+// RemoveUser stops mining with the user, leaves its chat and unlistens its topics.
+// Streamers only mined by this user are removed as well.
+func (miner *Miner) RemoveUser(username string) error {
+	miner.Lock.Lock()
+	user, ok := miner.Users[username]
+	if !ok {
+		miner.Lock.Unlock()
+		return fmt.Errorf("user %s not found", username)
+	}
+	delete(miner.Users, username)
+	if miner.DefaultUser == user {
+		// EventSub switches to the new default user below
+		miner.DefaultUser = nil
+		for _, other := range miner.Users {
+			miner.DefaultUser = other
+			break
+		}
+	}
+	eventSub := miner.eventSub
+	streamers := slices.Collect(maps.Values(user.Streamers))
+	clear(user.Streamers)
+	orphaned := miner.orphanStreamers(streamers)
+	topics := user.topics
+	chat := user.Chat
+	miner.Lock.Unlock()
+
+	if eventSub != nil {
+		go eventSub.useDefaultUser()
+	}
+	if chat != nil {
+		chat.Stop()
+	}
+	for _, streamer := range streamers {
+		streamer.forget(user)
+	}
+	if miner.PrometheusExporter != nil {
+		miner.PrometheusExporter.DeleteUser(user)
+	}
+
+	errs := []error{}
+	if len(topics) > 0 {
+		if err := miner.Transport.Unlisten(topics...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := miner.removeStreamers(orphaned); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// orphanStreamers removes the streamers no user mines anymore and their predictions, the caller must hold the lock
+func (miner *Miner) orphanStreamers(streamers []*Streamer) []*Streamer {
+	orphaned := []*Streamer{}
+	for _, streamer := range streamers {
+		used := false
+		for _, user := range miner.Users {
+			if _, ok := user.Streamers[streamer.Username]; ok {
+				used = true
+				break
+			}
+		}
+		if used {
+			continue
+		}
+
+		delete(miner.Streamers, streamer.Username)
+		maps.DeleteFunc(miner.Predictions, func(_ string, prediction *Prediction) bool {
+			event := prediction.Event()
+			return event != nil && event.ChannelID == streamer.ID
+		})
+		orphaned = append(orphaned, streamer)
+	}
+	return orphaned
+}
+
+// removeStreamers unlistens all topics of the orphaned streamers
+func (miner *Miner) removeStreamers(streamers []*Streamer) error {
+	topics := []*WebsocketTopic{}
+	for _, streamer := range streamers {
+		topics = append(topics, streamer.remove()...)
+		if miner.PrometheusExporter != nil {
+			miner.PrometheusExporter.DeleteStreamer(streamer)
+		}
+	}
+
+	if len(topics) == 0 {
+		return nil
+	}
+	return miner.Transport.Unlisten(topics...)
 }
 
 func (miner *Miner) GetStreamerByID(streamerID string) *Streamer {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+
 	for _, streamer := range miner.Streamers {
 		if streamer.ID == streamerID {
 			return streamer
@@ -108,9 +462,16 @@ func (miner *Miner) GetStreamerByID(streamerID string) *Streamer {
 	return nil
 }
 
+// GetUsersForStreamer returns the users mining the streamer, paused users are left out
 func (miner *Miner) GetUsersForStreamer(id string) []*User {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+
 	users := []*User{}
 	for _, user := range miner.Users {
+		if user.authError() != nil {
+			continue
+		}
 		for _, streamer := range user.Streamers {
 			if streamer.ID == id {
 				users = append(users, user)
@@ -121,112 +482,376 @@ func (miner *Miner) GetUsersForStreamer(id string) []*User {
 }
 
 func (miner *Miner) SubscribeToTopics() {
+	// collect everything first so the pool can batch them into as few LISTENs as possible
+	topics := []*WebsocketTopic{}
+	for _, user := range miner.GetUsers() {
+		topics = append(topics, miner.userTopics(user)...)
+	}
+	for _, streamer := range miner.GetStreamers() {
+		topics = append(topics, miner.streamerTopics(streamer)...)
+	}
+
+	if err := miner.Transport.Listen(topics...); err != nil {
+		fmt.Println("Error listening to topics", err)
+	}
+}
+
+// userTopics returns the topics to listen to for the user, or nothing if they were already returned before
+func (miner *Miner) userTopics(user *User) []*WebsocketTopic {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+
+	if user.topics != nil || user.authError() != nil {
+		return nil
+	}
+	user.topics = miner.wantedUserTopics(user)
+	return user.topics
+}
+
+// wantedUserTopics returns the topics the options need for the user
+func (miner *Miner) wantedUserTopics(user *User) []*WebsocketTopic {
+	topics := []*WebsocketTopic{{Topic: "community-points-user-v1", User: user}}
+	if miner.GetOptions().ForUser(user.Username).AnyStreamer(func(o Options) bool { return o.MinePredictions }) {
+		topics = append(topics, &WebsocketTopic{Topic: "predictions-user-v1", User: user})
+	}
+	return topics
+}
+
+// streamerTopics returns the topics to listen to for the streamer, or nothing if they were already returned before.
+// The live topics are listened to by UpdateStreamerTopicSubscriptions.
+func (miner *Miner) streamerTopics(streamer *Streamer) []*WebsocketTopic {
+	topics, liveTopics := miner.wantedStreamerTopics(streamer)
+	if !streamer.setTopics(topics, liveTopics) {
+		return nil
+	}
+	return topics
+}
+
+// updateTopics listens to the topics the options need now and unlistens the ones they do not need anymore.
+// Users and streamers that were not listened to yet are left out.
+func (miner *Miner) updateTopics() {
+	listen := []*WebsocketTopic{}
+	unlisten := []*WebsocketTopic{}
+
+	miner.Lock.Lock()
 	for _, user := range miner.Users {
-		channelPointsTopic := WebsocketTopic{Topic: "community-points-user-v1", User: user}
-		if err := miner.WebsocketPool.ListenTopic(&channelPointsTopic); err != nil {
-			fmt.Println("Error listening to channel points topic", err)
+		// paused users get their topics once they are added again
+		if user.topics == nil {
+			continue
 		}
+		var added, removed []*WebsocketTopic
+		user.topics, added, removed = mergeTopics(user.topics, miner.wantedUserTopics(user))
+		listen = append(listen, added...)
+		unlisten = append(unlisten, removed...)
+	}
+	miner.Lock.Unlock()
 
-		if miner.Options.MinePredictions {
-			predictionTopic := WebsocketTopic{Topic: "predictions-user-v1", User: user}
-			if err := miner.WebsocketPool.ListenTopic(&predictionTopic); err != nil {
-				fmt.Println("Error listening to predictions topic", err)
-			}
+	for _, streamer := range miner.GetStreamers() {
+		added, removed := streamer.replaceTopics(miner.wantedStreamerTopics(streamer))
+		listen = append(listen, added...)
+		unlisten = append(unlisten, removed...)
+	}
+
+	if len(unlisten) > 0 {
+		if err := miner.Transport.Unlisten(unlisten...); err != nil {
+			fmt.Println("Error unlistening topics", err)
 		}
 	}
-	for _, streamer := range miner.Streamers {
-		if miner.Options.RequiresStreamActivity() {
-			videoPlaybackTopic := WebsocketTopic{Topic: "video-playback-by-id", Streamer: streamer}
-			if err := miner.WebsocketPool.ListenTopic(&videoPlaybackTopic); err != nil {
-				fmt.Println("Error listening to video playback topic", err)
-			}
+	if len(listen) > 0 {
+		if err := miner.Transport.Listen(listen...); err != nil {
+			fmt.Println("Error listening to topics", err)
 		}
+	}
+}
 
-		if miner.Options.MineRaids {
-			raidTopic := WebsocketTopic{Topic: "raid", Streamer: streamer}
-			streamer.LiveTopics = append(streamer.LiveTopics, &raidTopic)
+// mergeTopics returns the wanted topics with the current ones kept where the topic stays, so transports see the same topic.
+// added and removed are the topics to listen to and unlisten.
+func mergeTopics(current []*WebsocketTopic, wanted []*WebsocketTopic) (merged []*WebsocketTopic, added []*WebsocketTopic, removed []*WebsocketTopic) {
+	merged = []*WebsocketTopic{}
+	for _, topic := range wanted {
+		i := slices.IndexFunc(current, func(other *WebsocketTopic) bool { return other.Topic == topic.Topic })
+		if i >= 0 {
+			merged = append(merged, current[i])
+		} else {
+			merged = append(merged, topic)
+			added = append(added, topic)
 		}
-		if miner.Options.MineMoments {
-			momentTopic := WebsocketTopic{Topic: "community-moments-channel-v1", Streamer: streamer}
-			streamer.LiveTopics = append(streamer.LiveTopics, &momentTopic)
-		}
-		if miner.Options.MinePredictions {
-			predictionTopic := WebsocketTopic{Topic: "predictions-channel-v1", Streamer: streamer}
-			streamer.LiveTopics = append(streamer.LiveTopics, &predictionTopic)
+	}
+	for _, topic := range current {
+		if !slices.Contains(merged, topic) {
+			removed = append(removed, topic)
 		}
 	}
+	return merged, added, removed
+}
+
+// wantedStreamerTopics returns the topics the options need for the streamer, the live topics are only needed while it is live
+func (miner *Miner) wantedStreamerTopics(streamer *Streamer) (topics []*WebsocketTopic, liveTopics []*WebsocketTopic) {
+	options := miner.GetOptions()
+	// the topics are shared by all users, the handlers skip the users that disabled the feature
+	needs := func(enabled func(Options) bool) bool {
+		return options.AnyUser(streamer.Username, enabled)
+	}
+	topics = []*WebsocketTopic{}
+	if needs(Options.RequiresStreamActivity) {
+		topics = append(topics, &WebsocketTopic{Topic: "video-playback-by-id", Streamer: streamer})
+	}
+
+	liveTopics = []*WebsocketTopic{}
+	if needs(func(o Options) bool { return o.MineRaids }) {
+		liveTopics = append(liveTopics, &WebsocketTopic{Topic: "raid", Streamer: streamer})
+	}
+	if needs(func(o Options) bool { return o.MineMoments }) {
+		liveTopics = append(liveTopics, &WebsocketTopic{Topic: "community-moments-channel-v1", Streamer: streamer})
+	}
+	if needs(func(o Options) bool { return o.MinePredictions }) {
+		liveTopics = append(liveTopics, &WebsocketTopic{Topic: "predictions-channel-v1", Streamer: streamer})
+	}
+	return topics, liveTopics
 }
 
-func (miner *Miner) Run() error {
+// Run mines until ctx is cancelled and shuts down gracefully afterwards
+func (miner *Miner) Run(ctx context.Context) error {
+	if err := miner.Start(ctx); err != nil {
+		return err
+	}
+	return miner.Wait()
+}
+
+// Start connects to Twitch and mines in the background until ctx is cancelled or Stop is called.
+// A miner can only be started once.
+func (miner *Miner) Start(ctx context.Context) error {
+	miner.Lock.Lock()
+	if miner.cancel != nil {
+		miner.Lock.Unlock()
+		return fmt.Errorf("miner was already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	miner.ctx = ctx
+	miner.cancel = cancel
+	miner.Lock.Unlock()
+
+	handled, err := miner.start()
+	if err != nil {
+		cancel()
+		miner.stopErr = err
+		close(miner.stopped)
+		return err
+	}
+
+	go func() {
+		miner.Scheduler.Run(ctx)
+		miner.stopErr = miner.shutdown(handled)
+		close(miner.stopped)
+	}()
+	return nil
+}
+
+// start connects everything, handled is closed once all messages of the transport were handled
+func (miner *Miner) start() (<-chan struct{}, error) {
 	fmt.Println("Starting miner")
+	options := miner.GetOptions()
+	defaultUser := miner.GetDefaultUser()
+	if defaultUser == nil {
+		return nil, fmt.Errorf("no users were added")
+	}
 
 	err := miner.UpdateVersions()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Initialize and start Prometheus exporter if enabled
-	if miner.Options.PrometheusEnabled {
+	if options.PrometheusEnabled {
 		exporter, err := NewPrometheusExporter(miner)
 		if err != nil {
-			return fmt.Errorf("failed to initialize Prometheus exporter: %w", err)
+			return nil, fmt.Errorf("failed to initialize Prometheus exporter: %w", err)
 		}
 		miner.PrometheusExporter = exporter
-		go func() {
-			if err := miner.PrometheusExporter.StartServer(miner.Options.PrometheusHost, miner.Options.PrometheusPort); err != nil {
-				fmt.Printf("Error starting Prometheus server: %v\n", err)
-			}
-		}()
+		exporter.Subscribe(miner.Events)
+		if err := exporter.StartServer(options.PrometheusHost, options.PrometheusPort); err != nil {
+			fmt.Printf("Error starting Prometheus server: %v\n", err)
+		}
 	}
 
-	if miner.Options.MineWatchtime {
-		for _, user := range miner.Users {
-			fmt.Println("Connecting to chat for user", user.Username)
+	for _, user := range miner.GetUsers() {
+		if options.ForUser(user.Username).AnyStreamer(func(o Options) bool { return o.MineWatchtime }) {
 			user.ConnectToChat()
 		}
 	}
 
-	onMessage := miner.OnMessage
-	miner.WebsocketPool.OnMessage = &onMessage
+	if options.Transport == TransportEventSub {
+		fmt.Println("Using EventSub for streamer events")
+		// EventSub needs a user token, so it can only be created once the users are known
+		eventSub := NewEventSubConnection(miner, miner.Transport)
+		miner.Lock.Lock()
+		miner.eventSub = eventSub
+		miner.Lock.Unlock()
+		miner.Transport = NewRoutedTransport(eventSub, miner.Transport)
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		miner.HandleMessages()
+	}()
 	miner.SubscribeToTopics()
 
+	if miner.Recorder != nil {
+		if err := miner.Recorder.RecordAccounts(miner); err != nil {
+			fmt.Println("Error recording accounts", err)
+		}
+	}
+
 	fmt.Println("Miner is running")
-	fmt.Println(len(miner.WebsocketPool.connections), "websocket connections")
+	fmt.Println(len(miner.Transport.Topics()), "topics")
 
-	for i := 0; ; i++ {
-		time.Sleep(time.Minute)
-		if miner.Options.RequiresStreamActivity() {
-			if err := miner.UpdateStreamerTopicSubscriptions(); err != nil {
-				fmt.Println("Error updating streamer topic subscriptions", err)
-			}
-		}
+	miner.addTasks()
+	return handled, nil
+}
 
-		if miner.Options.MineWatchtime {
-			for _, user := range miner.Users {
-				user.Chat.RevalidateChannelSubscriptions()
-			}
-		}
+// Stop stops the miner and waits until it shut down gracefully
+func (miner *Miner) Stop() error {
+	miner.Lock.Lock()
+	cancel := miner.cancel
+	miner.Lock.Unlock()
+
+	if cancel == nil {
+		return fmt.Errorf("miner was not started")
+	}
+	cancel()
+	return miner.Wait()
+}
 
-		if miner.Options.MinePoints {
-			for _, user := range miner.Users {
+// Wait blocks until the miner stopped and returns why it could not start or the errors of the shutdown
+func (miner *Miner) Wait() error {
+	<-miner.stopped
+	return miner.stopErr
+}
+
+// running returns whether the miner was started and is not stopping, the caller must hold the lock
+func (miner *Miner) running() bool {
+	return miner.cancel != nil && miner.ctx.Err() == nil
+}
+
+// addTasks registers all periodic work with the scheduler
+func (miner *Miner) addTasks() {
+	options := miner.GetOptions()
+	schedule := DefaultSchedule()
+	maps.Copy(schedule, options.Schedule)
+
+	// users and streamers can enable features that are disabled globally, so tasks are added if anyone needs them
+	if options.Any(taskFeatures[TaskTopics]) {
+		miner.Scheduler.Add(TaskTopics, schedule[TaskTopics], miner.UpdateStreamerTopicSubscriptions)
+	}
+	if options.Any(taskFeatures[TaskChat]) {
+		miner.Scheduler.Add(TaskChat, schedule[TaskChat], func() error {
+			for _, user := range miner.GetUsers() {
+				if chat := user.GetChat(); chat != nil {
+					chat.RevalidateChannelSubscriptions()
+				}
+			}
+			return nil
+		})
+	}
+	if options.Any(taskFeatures[TaskPoints]) {
+		miner.Scheduler.Add(TaskPoints, schedule[TaskPoints], func() error {
+			errs := []error{}
+			for _, user := range miner.GetUsers() {
+				if user.AuthError() != nil {
+					continue
+				}
 				if err := miner.MinePoints(user); err != nil {
-					fmt.Println("Error mining points", err)
+					errs = append(errs, fmt.Errorf("%s: %w", user.Username, err))
 				}
 			}
-		}
-
-		// once an hour should be enough
-		if i%60 == 59 {
-			err := miner.UpdateVersions()
-			if err != nil {
-				fmt.Println("Error updating versions", err)
+			return errors.Join(errs...)
+		})
+	}
+	miner.Scheduler.Add(TaskVersions, schedule[TaskVersions], miner.UpdateVersions)
+	miner.Scheduler.Add(TaskTokens, schedule[TaskTokens], miner.RevalidateTokens)
+	if options.Any(taskFeatures[TaskFollows]) {
+		miner.Scheduler.Add(TaskFollows, schedule[TaskFollows], func() error {
+			errs := []error{}
+			for _, user := range miner.GetUsers() {
+				if !miner.GetOptions().ForUser(user.Username).FollowStreamers || user.AuthError() != nil {
+					continue
+				}
+				if err := miner.SyncFollows(user); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", user.Username, err))
+				}
 			}
+			return errors.Join(errs...)
+		})
+	}
+	if options.PrometheusEnabled && miner.PrometheusExporter != nil {
+		miner.Scheduler.Add(TaskMetrics, schedule[TaskMetrics], func() error {
+			miner.PrometheusExporter.UpdateMetrics()
+			return nil
+		})
+	}
+}
+
+// HandleMessages handles all messages of the transport until it is closed
+func (miner *Miner) HandleMessages() {
+	for message := range miner.Transport.Messages() {
+		miner.inflight.Add(1)
+		go func() {
+			defer miner.inflight.Done()
+			miner.OnMessage(message)
+		}()
+	}
+}
+
+// shutdown stops listening for new events, waits for pending claims and bets and saves the state.
+// handled is closed once HandleMessages returned
+func (miner *Miner) shutdown(handled <-chan struct{}) error {
+	fmt.Println("Stopping miner")
+	errs := []error{}
+
+	for _, user := range miner.GetUsers() {
+		if chat := user.GetChat(); chat != nil {
+			chat.Stop()
 		}
+	}
+	if err := miner.Transport.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close transport: %w", err))
+	}
 
-		if miner.Options.PrometheusEnabled && miner.PrometheusExporter != nil {
-			miner.PrometheusExporter.UpdateMetrics()
+	// claims, bets and alerts that are already being sent should not be cut off halfway
+	done := make(chan struct{})
+	go func() {
+		<-handled
+		miner.inflight.Wait()
+		miner.Events.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		errs = append(errs, fmt.Errorf("gave up waiting for pending claims and bets after %s", shutdownTimeout))
+	}
+
+	miner.Lock.Lock()
+	if err := miner.Persistent.Save(miner.GetOptions()); err != nil {
+		errs = append(errs, fmt.Errorf("failed to save persistent state: %w", err))
+	}
+	miner.Lock.Unlock()
+
+	if miner.Recorder != nil {
+		if err := miner.Recorder.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close recorder: %w", err))
 		}
 	}
+	if miner.PrometheusExporter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := miner.PrometheusExporter.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop prometheus exporter: %w", err))
+		}
+	}
+
+	fmt.Println("Miner stopped")
+	return errors.Join(errs...)
 }
 
 func (miner *Miner) OnMessage(message WebsocketMessage) {
@@ -274,32 +899,38 @@ func (miner *Miner) OnMessage(message WebsocketMessage) {
 }
 
 func (miner *Miner) UpdateStreamerTopicSubscriptions() error {
-	for _, streamer := range miner.Streamers {
-		live := streamer.IsLive()
-		if live != streamer.WasLive {
+	listen := []*WebsocketTopic{}
+	unlisten := []*WebsocketTopic{}
+	for _, streamer := range miner.GetStreamers() {
+		if live, changed := streamer.UpdateLiveState(); changed {
 			if live {
-				for _, topic := range streamer.LiveTopics {
-					err := miner.WebsocketPool.ListenTopic(topic)
-					if err != nil {
-						fmt.Println("Error listening topic", err)
-					}
-				}
+				listen = append(listen, streamer.LiveTopics()...)
 			} else {
-				for _, topic := range streamer.LiveTopics {
-					err := miner.WebsocketPool.UnlistenTopic(topic)
-					if err != nil {
-						fmt.Println("Error unlistening topic", err)
-					}
-				}
+				unlisten = append(unlisten, streamer.LiveTopics()...)
 			}
-			streamer.WasLive = live
+		}
+	}
+
+	if len(listen) > 0 {
+		if err := miner.Transport.Listen(listen...); err != nil {
+			fmt.Println("Error listening topics", err)
+		}
+	}
+	if len(unlisten) > 0 {
+		if err := miner.Transport.Unlisten(unlisten...); err != nil {
+			fmt.Println("Error unlistening topics", err)
 		}
 	}
 	return nil
 }
 
 func (miner *Miner) UpdateVersions() error {
-	response, err := miner.DefaultUser.GraphQL.Client.Get("https://twitch.tv")
+	user := miner.GetDefaultUser()
+	if user == nil {
+		return fmt.Errorf("no users were added")
+	}
+
+	response, err := user.GraphQL.Client.Get(miner.GetOptions().Endpoints.Website)
 	if err != nil {
 		return err
 	}
@@ -309,16 +940,23 @@ func (miner *Miner) UpdateVersions() error {
 		return err
 	}
 
-	buildID := strings.Split(strings.Split(string(text), "__twilightBuildID=\"")[1], "\"")[0]
-	for _, user := range miner.Users {
-		user.GraphQL.ClientVersion = buildID
+	_, buildID, found := strings.Cut(string(text), "__twilightBuildID=\"")
+	buildID, _, _ = strings.Cut(buildID, "\"")
+	if !found || buildID == "" {
+		return fmt.Errorf("could not find the client version")
+	}
+	miner.Lock.Lock()
+	miner.clientVersion = buildID
+	miner.Lock.Unlock()
+	for _, user := range miner.GetUsers() {
+		user.GraphQL.SetClientVersion(buildID)
 	}
 
 	fmt.Println("Client version", buildID)
 
-	regex := regexp.MustCompile(`https:\/\/[a-z-.]+\/config\/settings\.[^.]+\.js`)
+	regex := regexp.MustCompile(`https?:\/\/[a-z0-9-.:]+\/config\/settings\.[^.]+\.js`)
 	url := regex.FindString(string(text))
-	response, err = miner.DefaultUser.GraphQL.Client.Get(url)
+	response, err = user.GraphQL.Client.Get(url)
 	if err != nil {
 		return err
 	}
@@ -328,29 +966,73 @@ func (miner *Miner) UpdateVersions() error {
 		return err
 	}
 
-	spadeUrl := strings.Split(strings.Split(string(text), "\"spade_url\":\"")[1], "\"")[0]
-	miner.SpadeUrl = spadeUrl
+	_, spadeUrl, found := strings.Cut(string(text), "\"spade_url\":\"")
+	spadeUrl, _, _ = strings.Cut(spadeUrl, "\"")
+	if !found || spadeUrl == "" {
+		return fmt.Errorf("could not find the spade url")
+	}
+	miner.Lock.Lock()
+	miner.spadeURL = spadeUrl
+	miner.Lock.Unlock()
 
 	fmt.Println("Spade URL", spadeUrl)
 
 	return nil
 }
 
+// SpadeURL returns the URL watch events are sent to, it is loaded by UpdateVersions
+func (miner *Miner) SpadeURL() string {
+	miner.Lock.Lock()
+	defer miner.Lock.Unlock()
+	return miner.spadeURL
+}
+
 func NewMiner(options Options) *Miner {
-	pool := NewWebsocketPool()
+	options.Endpoints = options.Endpoints.WithDefaults()
+	clock := clockOrReal(options.Clock)
+	pool := NewWebsocketPool(options.Endpoints.PubSub, clock)
+	pool.MaxConnectionsPerMinute = options.PubSubConnectionsPerMinute
 	state := LoadPersistentState(options)
+	events := NewEventBus()
+	pool.Events = events
+
+	var recorder *Recorder
+	if options.RecordFile != "" {
+		var err error
+		recorder, err = NewRecorder(options.RecordFile)
+		if err != nil {
+			fmt.Println("Failed to open record file", err)
+		} else {
+			pool.Recorder = recorder
+		}
+	}
 
 	miner := &Miner{
 		options,
+		clock,
 		pool,
+		recorder,
+		events,
 		nil,
 		map[string]*User{},
 		map[string]*Streamer{},
 		map[string]*Prediction{},
 		state,
 		"",
+		"",
+		map[string]error{},
+		nil,
+		NewScheduler(clock),
+		nil,
+		context.Background(),
+		nil,
+		sync.WaitGroup{},
+		make(chan struct{}),
 		nil,
 		sync.Mutex{},
+		sync.RWMutex{},
 	}
+	miner.subscribeAlerts()
+	miner.subscribePersistence()
 	return miner
 }
@@ -0,0 +1,250 @@
+package miner_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+func pointsMessage(user *miner.User, channelID string, balance int) miner.WebsocketMessage {
+	data, _ := json.Marshal(map[string]any{
+		"type": "points-earned",
+		"data": map[string]any{
+			"balance":    map[string]any{"channel_id": channelID, "balance": balance},
+			"point_gain": map[string]any{"reason_code": "WATCH", "total_points": 10},
+		},
+	})
+	return miner.WebsocketMessage{Topics: []string{"community-points-user-v1", user.ID}, Type: "points-earned", Data: data}
+}
+
+func viewcountMessage(channelID string, viewers int) miner.WebsocketMessage {
+	data, _ := json.Marshal(map[string]any{"type": "viewcount", "viewers": viewers})
+	return miner.WebsocketMessage{Topics: []string{"video-playback-by-id", channelID}, Type: "viewcount", Data: data}
+}
+
+// TestConcurrentStreamerUpdates adds streamers for two users while points and viewcounts arrive and metrics are read.
+// It only finds something with -race.
+func TestConcurrentStreamerUpdates(t *testing.T) {
+	script := &simulator.Script{Users: []simulator.ScriptUser{{Name: "alice"}, {Name: "bob"}}}
+	names := []string{}
+	for i := range 30 {
+		name := fmt.Sprintf("streamer%d", i)
+		names = append(names, name)
+		script.Channels = append(script.Channels, simulator.ScriptChannel{Name: name, Points: 1_000})
+	}
+	server := startSimulator(t, script)
+	instance, transport := newTestMiner(t, server, miner.WithClock(miner.NewFakeClock(start)), func(o *miner.Options) {
+		o.MineWatchtime = false
+	})
+	exporter, err := miner.NewPrometheusExporter(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(exporter.Unregister)
+	instance.PrometheusExporter = exporter
+	exporter.Subscribe(instance.Events)
+
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = instance.Stop() })
+	users := instance.GetUsers()
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, user := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := instance.BulkAddStreamers(user, names); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	updates := sync.WaitGroup{}
+	updates.Add(2)
+	go func() {
+		defer updates.Done()
+		for i := range 1_000 {
+			channel := script.Channels[i%len(script.Channels)]
+			transport.Publish(pointsMessage(users[i%len(users)], channel.ID, 1_000+i))
+			transport.Publish(viewcountMessage(channel.ID, i))
+		}
+	}()
+	go func() {
+		defer updates.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			exporter.UpdateMetrics()
+		}
+	}()
+	wg.Wait()
+	close(done)
+	updates.Wait()
+
+	// every streamer was added once and is updated by the messages
+	if got := len(instance.GetStreamers()); got != len(names) {
+		t.Fatalf("%d streamers, want %d", got, len(names))
+	}
+	for _, user := range users {
+		for _, channel := range script.Channels {
+			transport.Publish(pointsMessage(user, channel.ID, 5_000))
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for _, streamer := range instance.GetStreamers() {
+		for _, user := range users {
+			for streamer.Points(user) != 5_000 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := streamer.Points(user); got != 5_000 {
+				t.Errorf("%s has %d points on %s, want 5000", user.Username, got, streamer.Username)
+			}
+		}
+	}
+}
+
+func TestSyncFollows(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user", Follows: []string{"first", "second"}}},
+		Channels: []simulator.ScriptChannel{{Name: "first"}, {Name: "second"}},
+	})
+	instance, _ := newTestMiner(t, server)
+	user := instance.GetDefaultUser()
+	if err := instance.AddStreamersFromFollows(user); err != nil {
+		t.Fatal(err)
+	}
+
+	unfollow := func(channel string) {
+		t.Helper()
+		if err := server.Emit(simulator.Event{Type: simulator.EventUnfollow, Channel: channel}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unfollow("first")
+	if err := instance.SyncFollows(user); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(instance.GetStreamers()); got != 1 {
+		t.Errorf("%d streamers after unfollowing one, want 1", got)
+	}
+
+	// an empty answer is more likely a hiccup of Twitch than unfollowing everything
+	unfollow("second")
+	if err := instance.SyncFollows(user); err == nil {
+		t.Error("syncing without any follows should fail")
+	}
+	if got := len(instance.GetStreamers()); got != 1 {
+		t.Errorf("%d streamers after getting no follows, want 1", got)
+	}
+}
+
+func TestUpdateOptionsStreamerOverrides(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}},
+	})
+	instance, transport := newTestMiner(t, server, miner.WithClock(miner.NewFakeClock(start)), func(o *miner.Options) {
+		o.MineWatchtime = false
+	})
+	streamer, err := instance.AddStreamer("streamer", instance.GetDefaultUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = instance.Stop() })
+	streamer.StreamUp()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+
+	disabled := false
+	options := instance.GetOptions()
+	miner.WithStreamerOverrides("streamer", miner.OptionOverrides{MineRaids: &disabled, MinePredictions: &disabled})(&options)
+	if err := instance.UpdateOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	assertListening(t, transport,
+		"community-points-user-v1.1000", "predictions-user-v1.1000",
+		"video-playback-by-id.2000", "community-moments-channel-v1.2000",
+	)
+
+	// the live topics that are needed again are listened to once the stream is up again
+	streamer.StreamDown()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	options.StreamerOverrides = nil
+	if err := instance.UpdateOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	assertListening(t, transport, "community-points-user-v1.1000", "predictions-user-v1.1000", "video-playback-by-id.2000")
+	streamer.StreamUp()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport,
+		"community-points-user-v1.1000", "predictions-user-v1.1000", "video-playback-by-id.2000",
+		"raid.2000", "community-moments-channel-v1.2000", "predictions-channel-v1.2000",
+	)
+
+	// nothing runs the chat task, so watch time can not be enabled for a single streamer
+	enabled := true
+	miner.WithStreamerOverrides("streamer", miner.OptionOverrides{MineWatchtime: &enabled})(&options)
+	if err := instance.UpdateOptions(options); err == nil {
+		t.Error("enabling watch time without the chat task running should require a restart")
+	}
+}
+
+func TestUpdateOptionsStreamerGroups(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}, {Name: "other"}},
+	})
+	instance, transport := newTestMiner(t, server, miner.WithClock(miner.NewFakeClock(start)), func(o *miner.Options) {
+		o.MineWatchtime = false
+		o.MineMoments = false
+		o.MinePredictions = false
+	})
+	if err := instance.BulkAddStreamers(instance.GetDefaultUser(), []string{"streamer", "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := instance.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = instance.Stop() })
+	for _, streamer := range instance.GetStreamers() {
+		streamer.StreamUp()
+	}
+	_ = instance.UpdateStreamerTopicSubscriptions()
+
+	// groups can only be active at times, so they add topics but do not remove the ones needed without them
+	enabled := true
+	options := instance.GetOptions()
+	options.StreamerGroups = []miner.StreamerGroup{{
+		Name: "bets", Streamers: []string{"streamer"},
+		OptionOverrides: miner.OptionOverrides{MinePredictions: &enabled},
+	}}
+	if err := instance.UpdateOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	assertListening(t, transport,
+		"community-points-user-v1.1000", "predictions-user-v1.1000",
+		"video-playback-by-id.2000", "video-playback-by-id.2001",
+		"raid.2000", "raid.2001", "predictions-channel-v1.2000",
+	)
+
+	options.StreamerGroups = nil
+	if err := instance.UpdateOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	assertListening(t, transport,
+		"community-points-user-v1.1000", "video-playback-by-id.2000", "video-playback-by-id.2001", "raid.2000", "raid.2001",
+	)
+}
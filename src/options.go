@@ -1,12 +1,31 @@
 package miner
 
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
 type Options struct {
 	MinePoints           bool
 	PrioritizeStreaks    bool
 	ConcurrentPointLimit int
 	ConcurrentWatchLimit int
 	MiningStrategy       MiningStrategy
-	StreamerPriority     map[string]int
+	// StreamerPriority also pins the streamers, they are never removed by the follows task
+	StreamerPriority map[string]int
+	// StreamerOverrides change the options for single streamers, see ForStreamer
+	StreamerOverrides map[string]OptionOverrides
+	// StreamerGroups change the options and priority of many streamers at once, their streamers are pinned as well
+	StreamerGroups []StreamerGroup
+	// UserOptions change the options for single users, see ForUser
+	UserOptions map[string]UserOptions
+	// ExcludeStreamers are never mined, even if they are followed or have a priority
+	ExcludeStreamers []string
+	// FollowStreamers mines every streamer the users follow, the follows task keeps them in sync
+	FollowStreamers bool
 
 	MineRaids   bool
 	MineMoments bool
@@ -25,12 +44,426 @@ type Options struct {
 
 	PersistentFile string
 	DebugWebhook   string
+	// RecordFile is a JSONL file every received PubSub message is appended to
+	RecordFile string
+
+	Endpoints Endpoints
+	// Transport selects where streamer events come from, see the Transport constants
+	Transport TransportType
+	// PubSubConnectionsPerMinute caps how many PubSub connections are opened per minute
+	PubSubConnectionsPerMinute int
+
+	// Clock is used for all timing, defaults to RealClock. Tests can pass a FakeClock
+	Clock Clock
+
+	// Schedule overrides the DefaultSchedule of the periodic tasks by name
+	Schedule map[string]TaskSchedule
 
 	PrometheusEnabled bool
 	PrometheusPort    int
 	PrometheusHost    string
 }
 
+// DefaultOptions are the options used by New, they match the defaults of the config file
+func DefaultOptions() Options {
+	return Options{
+		MinePoints:           true,
+		PrioritizeStreaks:    true,
+		ConcurrentPointLimit: 2,
+		ConcurrentWatchLimit: 0,
+		MiningStrategy:       MiningStrategyLeastPoints,
+		StreamerPriority:     map[string]int{},
+		StreamerOverrides:    map[string]OptionOverrides{},
+		UserOptions:          map[string]UserOptions{},
+		FollowStreamers:      true,
+
+		MineRaids:   true,
+		MineMoments: true,
+
+		MineWatchtime:     true,
+		WatchTimeOnlyLive: true,
+		FollowChatSpam:    false,
+
+		MinePredictions:       true,
+		PredictionsDataPoints: 5,
+		PredictionsMinPoints:  1_000,
+		PredictionsMaxBet:     50_000,
+		PredictionsMaxRatio:   2,
+		PredictionsStealth:    false,
+		PredictionsStrategy:   PredictionStrategyCautious,
+
+		PersistentFile: "persistent.json",
+
+		Endpoints:                  DefaultEndpoints(),
+		Transport:                  TransportPubSub,
+		PubSubConnectionsPerMinute: 10,
+
+		Schedule: DefaultSchedule(),
+
+		PrometheusPort: 8080,
+		PrometheusHost: "localhost",
+	}
+}
+
+// Validate returns an error for every option the miner can not work with
+func (o Options) Validate() error {
+	errs := []error{}
+	if !slices.Contains(miningStrategies, o.MiningStrategy) {
+		errs = append(errs, fmt.Errorf("invalid mining strategy %q", o.MiningStrategy))
+	}
+	if !slices.Contains(predictionStrategies, o.PredictionsStrategy) {
+		errs = append(errs, fmt.Errorf("invalid prediction strategy %q", o.PredictionsStrategy))
+	}
+	for streamer, overrides := range o.StreamerOverrides {
+		if overrides.PredictionsStrategy != nil && !slices.Contains(predictionStrategies, *overrides.PredictionsStrategy) {
+			errs = append(errs, fmt.Errorf("invalid prediction strategy %q for streamer %s", *overrides.PredictionsStrategy, streamer))
+		}
+	}
+	names := []string{}
+	for _, group := range o.StreamerGroups {
+		if group.Name == "" || slices.Contains(names, group.Name) {
+			errs = append(errs, fmt.Errorf("streamer groups need a unique name, got %q", group.Name))
+		}
+		names = append(names, group.Name)
+		if group.PredictionsStrategy != nil && !slices.Contains(predictionStrategies, *group.PredictionsStrategy) {
+			errs = append(errs, fmt.Errorf("invalid prediction strategy %q for group %s", *group.PredictionsStrategy, group.Name))
+		}
+		for _, window := range group.Schedule {
+			if err := window.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("group %s: %w", group.Name, err))
+			}
+		}
+	}
+	for user, options := range o.UserOptions {
+		if options.MiningStrategy != nil && !slices.Contains(miningStrategies, *options.MiningStrategy) {
+			errs = append(errs, fmt.Errorf("invalid mining strategy %q for user %s", *options.MiningStrategy, user))
+		}
+		if options.PredictionsStrategy != nil && !slices.Contains(predictionStrategies, *options.PredictionsStrategy) {
+			errs = append(errs, fmt.Errorf("invalid prediction strategy %q for user %s", *options.PredictionsStrategy, user))
+		}
+	}
+	if o.Transport != TransportPubSub && o.Transport != TransportEventSub {
+		errs = append(errs, fmt.Errorf("invalid transport %q", o.Transport))
+	}
+	for name, schedule := range o.Schedule {
+		if schedule.Interval < 0 || schedule.Jitter < 0 {
+			errs = append(errs, fmt.Errorf("schedule of %s can not be negative", name))
+		}
+	}
+	if o.PrometheusEnabled && (o.PrometheusPort <= 0 || o.PrometheusPort > 65535) {
+		errs = append(errs, fmt.Errorf("invalid prometheus port %d", o.PrometheusPort))
+	}
+	return errors.Join(errs...)
+}
+
+// reloadableOptions can be changed by UpdateOptions while the miner is running.
+// All other options are only read on start or change which topics are needed.
+var reloadableOptions = []string{
+	"PrioritizeStreaks", "ConcurrentPointLimit", "ConcurrentWatchLimit", "MiningStrategy", "StreamerPriority", "StreamerOverrides",
+	"StreamerGroups", "UserOptions", "ExcludeStreamers",
+	"FollowChatSpam",
+	"PredictionsDataPoints", "PredictionsMinPoints", "PredictionsMaxBet", "PredictionsMaxRatio", "PredictionsStealth", "PredictionsStrategy",
+	"DebugWebhook",
+}
+
+// OptionChange is a single option that differs between two Options
+type OptionChange struct {
+	Name string
+	Old  any
+	New  any
+}
+
+func (c OptionChange) String() string {
+	switch c.Name {
+	case "DebugWebhook":
+		// webhook urls contain a secret
+		return c.Name + " changed"
+	case "StreamerOverrides", "StreamerGroups", "UserOptions":
+		// only pointers would be printed
+		return c.Name + " changed"
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.Name, c.Old, c.New)
+}
+
+// diffOptions returns the changed options in the order they are declared
+func diffOptions(old Options, new Options) []OptionChange {
+	changes := []OptionChange{}
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := range oldValue.NumField() {
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, OptionChange{oldValue.Type().Field(i).Name, a, b})
+		}
+	}
+	return changes
+}
+
 func (o Options) RequiresStreamActivity() bool {
 	return o.MinePoints || o.MineRaids || o.MineMoments || o.MinePredictions || (o.MineWatchtime && o.WatchTimeOnlyLive)
 }
+
+// OptionOverrides replace options for a single streamer, nil fields keep the option
+type OptionOverrides struct {
+	MinePoints      *bool
+	MineRaids       *bool
+	MinePredictions *bool
+	MineWatchtime   *bool
+	FollowChatSpam  *bool
+
+	PredictionsDataPoints *int
+	PredictionsMinPoints  *int
+	PredictionsMaxBet     *int
+	PredictionsMaxRatio   *int
+	PredictionsStealth    *bool
+	PredictionsStrategy   *PredictionStrategy
+}
+
+func (o OptionOverrides) apply(options *Options) {
+	override(&options.MinePoints, o.MinePoints)
+	override(&options.MineRaids, o.MineRaids)
+	override(&options.MinePredictions, o.MinePredictions)
+	override(&options.MineWatchtime, o.MineWatchtime)
+	override(&options.FollowChatSpam, o.FollowChatSpam)
+	override(&options.PredictionsDataPoints, o.PredictionsDataPoints)
+	override(&options.PredictionsMinPoints, o.PredictionsMinPoints)
+	override(&options.PredictionsMaxBet, o.PredictionsMaxBet)
+	override(&options.PredictionsMaxRatio, o.PredictionsMaxRatio)
+	override(&options.PredictionsStealth, o.PredictionsStealth)
+	override(&options.PredictionsStrategy, o.PredictionsStrategy)
+}
+
+func override[T any](option *T, value *T) {
+	if value != nil {
+		*option = *value
+	}
+}
+
+// ForStreamer returns the options used for the streamer right now, including the groups that are active.
+// Streamer options take precedence over user options, so use options.ForUser(user).ForStreamer(streamer) for both.
+func (o Options) ForStreamer(username string) Options {
+	now := clockOrReal(o.Clock).Now()
+	return o.forStreamer(username, func(group StreamerGroup) bool { return group.Active(now) })
+}
+
+func (o Options) forStreamer(username string, active func(StreamerGroup) bool) Options {
+	for _, group := range o.groupsOf(username) {
+		if active(group) {
+			group.OptionOverrides.apply(&o)
+		}
+	}
+	if overrides, ok := o.StreamerOverrides[username]; ok {
+		overrides.apply(&o)
+	}
+	return o
+}
+
+// anyTime returns whether enabled is true for the options of the streamer whenever its groups are active or not
+func (o Options) anyTime(streamer string, enabled func(Options) bool) bool {
+	if enabled(o.forStreamer(streamer, func(StreamerGroup) bool { return false })) ||
+		enabled(o.forStreamer(streamer, func(StreamerGroup) bool { return true })) {
+		return true
+	}
+	for _, group := range o.groupsOf(streamer) {
+		if enabled(o.forStreamer(streamer, func(other StreamerGroup) bool { return other.Name == group.Name })) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyStreamer returns whether enabled is true for the options of any streamer at any time.
+// Used to decide whether a feature is needed at all, since streamers can enable features that are disabled globally.
+func (o Options) AnyStreamer(enabled func(Options) bool) bool {
+	if enabled(o) {
+		return true
+	}
+	streamers := slices.Collect(maps.Keys(o.StreamerOverrides))
+	for _, group := range o.StreamerGroups {
+		streamers = append(streamers, group.Streamers...)
+	}
+	return slices.ContainsFunc(streamers, func(streamer string) bool { return o.anyTime(streamer, enabled) })
+}
+
+// UserOptions replace options for a single user, nil fields keep the option
+type UserOptions struct {
+	OptionOverrides
+
+	PrioritizeStreaks    *bool
+	ConcurrentPointLimit *int
+	ConcurrentWatchLimit *int
+	MiningStrategy       *MiningStrategy
+	FollowStreamers      *bool
+
+	// Streamers are mined by the user in addition to the ones in StreamerPriority, with their priority
+	Streamers map[string]int
+	// ExcludeStreamers are never mined by the user
+	ExcludeStreamers []string
+}
+
+func (u UserOptions) apply(options *Options) {
+	u.OptionOverrides.apply(options)
+	override(&options.PrioritizeStreaks, u.PrioritizeStreaks)
+	override(&options.ConcurrentPointLimit, u.ConcurrentPointLimit)
+	override(&options.ConcurrentWatchLimit, u.ConcurrentWatchLimit)
+	override(&options.MiningStrategy, u.MiningStrategy)
+	override(&options.FollowStreamers, u.FollowStreamers)
+
+	// the maps are shared with the global options
+	if len(u.Streamers) > 0 {
+		options.StreamerPriority = maps.Clone(options.StreamerPriority)
+		if options.StreamerPriority == nil {
+			options.StreamerPriority = map[string]int{}
+		}
+		maps.Copy(options.StreamerPriority, u.Streamers)
+	}
+	options.ExcludeStreamers = slices.Concat(options.ExcludeStreamers, u.ExcludeStreamers)
+}
+
+// ForUser returns the options used for the user
+func (o Options) ForUser(username string) Options {
+	if user, ok := o.UserOptions[username]; ok {
+		user.apply(&o)
+	}
+	return o
+}
+
+// Any returns whether enabled is true for the options of any user or streamer, see AnyStreamer
+func (o Options) Any(enabled func(Options) bool) bool {
+	if o.AnyStreamer(enabled) {
+		return true
+	}
+	for user := range o.UserOptions {
+		if o.ForUser(user).AnyStreamer(enabled) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyUser returns whether enabled is true for the options of the streamer for any user at any time
+func (o Options) AnyUser(streamer string, enabled func(Options) bool) bool {
+	if o.anyTime(streamer, enabled) {
+		return true
+	}
+	for user := range o.UserOptions {
+		if o.ForUser(user).anyTime(streamer, enabled) {
+			return true
+		}
+	}
+	return false
+}
+
+// Excluded returns whether the streamer must not be mined
+func (o Options) Excluded(streamer string) bool {
+	return slices.Contains(o.ExcludeStreamers, streamer)
+}
+
+// Pinned returns whether the streamer is mined even if it is not followed
+func (o Options) Pinned(streamer string) bool {
+	_, ok := o.StreamerPriority[streamer]
+	return (ok || len(o.groupsOf(streamer)) > 0) && !o.Excluded(streamer)
+}
+
+// PinnedStreamers returns the streamers with a priority or a group that are not excluded
+func (o Options) PinnedStreamers() []string {
+	streamers := slices.Collect(maps.Keys(o.StreamerPriority))
+	for _, group := range o.StreamerGroups {
+		streamers = append(streamers, group.Streamers...)
+	}
+	slices.Sort(streamers)
+	return slices.DeleteFunc(slices.Compact(streamers), func(streamer string) bool { return !o.Pinned(streamer) })
+}
+
+type TransportType = string
+
+const (
+	TransportPubSub TransportType = "pubsub"
+	// TransportEventSub uses EventSub for the streamer topics it supports, everything else stays on PubSub
+	TransportEventSub TransportType = "eventsub"
+)
+
+// Option changes the options of a miner created by New.
+// Options not covered by a With function can be changed with a custom func(*Options).
+type Option func(*Options)
+
+// WithOptions replaces all options, for example with options loaded from a config file
+func WithOptions(options Options) Option {
+	return func(o *Options) {
+		*o = options
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+func WithEndpoints(endpoints Endpoints) Option {
+	return func(o *Options) {
+		o.Endpoints = endpoints
+	}
+}
+
+func WithTransport(transport TransportType) Option {
+	return func(o *Options) {
+		o.Transport = transport
+	}
+}
+
+// WithPersistentFile sets where prediction results are stored, an empty path keeps them in memory
+func WithPersistentFile(path string) Option {
+	return func(o *Options) {
+		o.PersistentFile = path
+	}
+}
+
+// WithWebhook sends alerts to a Discord webhook
+func WithWebhook(url string) Option {
+	return func(o *Options) {
+		o.DebugWebhook = url
+	}
+}
+
+// WithPrometheus enables the Prometheus exporter
+func WithPrometheus(host string, port int) Option {
+	return func(o *Options) {
+		o.PrometheusEnabled = true
+		o.PrometheusHost = host
+		o.PrometheusPort = port
+	}
+}
+
+// WithSchedule overrides the schedule of a single task, see the Task constants
+func WithSchedule(task string, schedule TaskSchedule) Option {
+	return func(o *Options) {
+		// the map may be shared with the caller
+		o.Schedule = maps.Clone(o.Schedule)
+		if o.Schedule == nil {
+			o.Schedule = map[string]TaskSchedule{}
+		}
+		o.Schedule[task] = schedule
+	}
+}
+
+// WithUserOptions replaces options for a single user
+func WithUserOptions(user string, options UserOptions) Option {
+	return func(o *Options) {
+		o.UserOptions = maps.Clone(o.UserOptions)
+		if o.UserOptions == nil {
+			o.UserOptions = map[string]UserOptions{}
+		}
+		o.UserOptions[user] = options
+	}
+}
+
+// WithStreamerOverrides replaces options for a single streamer
+func WithStreamerOverrides(streamer string, overrides OptionOverrides) Option {
+	return func(o *Options) {
+		o.StreamerOverrides = maps.Clone(o.StreamerOverrides)
+		if o.StreamerOverrides == nil {
+			o.StreamerOverrides = map[string]OptionOverrides{}
+		}
+		o.StreamerOverrides[streamer] = overrides
+	}
+}
@@ -1,8 +1,12 @@
 package miner
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -16,11 +20,32 @@ type PrometheusExporter struct {
 	streamerLiveStatus *prometheus.GaugeVec
 	totalStreamers     prometheus.Gauge
 	totalUsers         prometheus.Gauge
+	userAuth           *prometheus.GaugeVec
+	pubsubTopics       *prometheus.GaugeVec
+	pubsubFailedTopics *prometheus.GaugeVec
+	taskLastRun        *prometheus.GaugeVec
+	taskLastDuration   *prometheus.GaugeVec
+	taskLastSuccess    *prometheus.GaugeVec
+	taskRuns           *prometheus.CounterVec
+	taskFailures       *prometheus.CounterVec
+	events             *prometheus.CounterVec
+	pointsEarned       *prometheus.CounterVec
+	betPoints          *prometheus.CounterVec
+	connectionsLost    *prometheus.CounterVec
+	eventsDropped      prometheus.CounterFunc
+
+	// taskCounts are the runs and failures of every task already added to the counters
+	taskCounts map[string]TaskStatus
+	server     *http.Server
+	// lock guards taskCounts and server. Writing and deleting the series of users and streamers holds it too,
+	// so a write that saw a user or streamer before its removal can not recreate the series after they were deleted
+	lock sync.Mutex
 }
 
 func NewPrometheusExporter(miner *Miner) (*PrometheusExporter, error) {
 	exporter := &PrometheusExporter{
-		miner: miner,
+		miner:      miner,
+		taskCounts: map[string]TaskStatus{},
 
 		streamerPoints: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -59,6 +84,110 @@ func NewPrometheusExporter(miner *Miner) (*PrometheusExporter, error) {
 				Help: "Total number of users configured",
 			},
 		),
+
+		userAuth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_user_auth_ok",
+				Help: "Whether Twitch accepts the token of the user (1) or the user is paused (0)",
+			},
+			[]string{"username"},
+		),
+
+		pubsubTopics: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_pubsub_topics",
+				Help: "Number of PubSub topics per state (PENDING, LISTENING, FAILED)",
+			},
+			[]string{"state"},
+		),
+
+		pubsubFailedTopics: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_pubsub_failed_topic",
+				Help: "PubSub topics Twitch refused to listen to (1 for each failed topic)",
+			},
+			[]string{"topic", "username", "error"},
+		),
+
+		taskLastRun: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_task_last_run_timestamp_seconds",
+				Help: "Unix time the scheduled task last started",
+			},
+			[]string{"task"},
+		),
+
+		taskLastDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_task_last_duration_seconds",
+				Help: "How long the last run of the scheduled task took",
+			},
+			[]string{"task"},
+		),
+
+		taskLastSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "twitch_task_last_success",
+				Help: "Whether the last run of the scheduled task succeeded (1) or failed (0)",
+			},
+			[]string{"task"},
+		),
+
+		taskRuns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_task_runs_total",
+				Help: "Number of runs of the scheduled task since the miner started",
+			},
+			[]string{"task"},
+		),
+
+		taskFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_task_failures_total",
+				Help: "Number of failed runs of the scheduled task since the miner started",
+			},
+			[]string{"task"},
+		),
+
+		events: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_events_total",
+				Help: "Number of miner events by name (points_earned, bet_placed, stream_up, ...)",
+			},
+			[]string{"event"},
+		),
+
+		pointsEarned: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_points_earned_total",
+				Help: "Channel points earned for each user-streamer combination by reason (WATCH, CLAIM, ...)",
+			},
+			[]string{"username", "streamer", "reason"},
+		),
+
+		betPoints: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_bet_points_total",
+				Help: "Channel points bet on predictions for each user-streamer combination",
+			},
+			[]string{"username", "streamer"},
+		),
+
+		connectionsLost: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "twitch_connections_lost_total",
+				Help: "Number of unexpectedly lost connections by kind (pubsub, eventsub, chat)",
+			},
+			[]string{"kind"},
+		),
+
+		eventsDropped: prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "twitch_events_dropped_total",
+				Help: "Number of events a subscriber missed because it fell too far behind",
+			},
+			func() float64 { return float64(miner.Events.Dropped()) },
+		),
 	}
 
 	if err := prometheus.Register(exporter.streamerPoints); err != nil {
@@ -76,6 +205,45 @@ func NewPrometheusExporter(miner *Miner) (*PrometheusExporter, error) {
 	if err := prometheus.Register(exporter.totalUsers); err != nil {
 		return nil, fmt.Errorf("failed to register totalUsers: %w", err)
 	}
+	if err := prometheus.Register(exporter.userAuth); err != nil {
+		return nil, fmt.Errorf("failed to register userAuth: %w", err)
+	}
+	if err := prometheus.Register(exporter.pubsubTopics); err != nil {
+		return nil, fmt.Errorf("failed to register pubsubTopics: %w", err)
+	}
+	if err := prometheus.Register(exporter.pubsubFailedTopics); err != nil {
+		return nil, fmt.Errorf("failed to register pubsubFailedTopics: %w", err)
+	}
+	if err := prometheus.Register(exporter.taskLastRun); err != nil {
+		return nil, fmt.Errorf("failed to register taskLastRun: %w", err)
+	}
+	if err := prometheus.Register(exporter.taskLastDuration); err != nil {
+		return nil, fmt.Errorf("failed to register taskLastDuration: %w", err)
+	}
+	if err := prometheus.Register(exporter.taskLastSuccess); err != nil {
+		return nil, fmt.Errorf("failed to register taskLastSuccess: %w", err)
+	}
+	if err := prometheus.Register(exporter.taskRuns); err != nil {
+		return nil, fmt.Errorf("failed to register taskRuns: %w", err)
+	}
+	if err := prometheus.Register(exporter.taskFailures); err != nil {
+		return nil, fmt.Errorf("failed to register taskFailures: %w", err)
+	}
+	if err := prometheus.Register(exporter.events); err != nil {
+		return nil, fmt.Errorf("failed to register events: %w", err)
+	}
+	if err := prometheus.Register(exporter.pointsEarned); err != nil {
+		return nil, fmt.Errorf("failed to register pointsEarned: %w", err)
+	}
+	if err := prometheus.Register(exporter.betPoints); err != nil {
+		return nil, fmt.Errorf("failed to register betPoints: %w", err)
+	}
+	if err := prometheus.Register(exporter.connectionsLost); err != nil {
+		return nil, fmt.Errorf("failed to register connectionsLost: %w", err)
+	}
+	if err := prometheus.Register(exporter.eventsDropped); err != nil {
+		return nil, fmt.Errorf("failed to register eventsDropped: %w", err)
+	}
 
 	return exporter, nil
 }
@@ -86,19 +254,109 @@ func (e *PrometheusExporter) Unregister() {
 	prometheus.Unregister(e.streamerLiveStatus)
 	prometheus.Unregister(e.totalStreamers)
 	prometheus.Unregister(e.totalUsers)
+	prometheus.Unregister(e.userAuth)
+	prometheus.Unregister(e.pubsubTopics)
+	prometheus.Unregister(e.pubsubFailedTopics)
+	prometheus.Unregister(e.taskLastRun)
+	prometheus.Unregister(e.taskLastDuration)
+	prometheus.Unregister(e.taskLastSuccess)
+	prometheus.Unregister(e.taskRuns)
+	prometheus.Unregister(e.taskFailures)
+	prometheus.Unregister(e.events)
+	prometheus.Unregister(e.pointsEarned)
+	prometheus.Unregister(e.betPoints)
+	prometheus.Unregister(e.connectionsLost)
+	prometheus.Unregister(e.eventsDropped)
 }
 
-func (e *PrometheusExporter) UpdateMetrics() {
+// Subscribe counts the events of the miner
+func (e *PrometheusExporter) Subscribe(bus *EventBus) {
+	bus.Subscribe(func(event Event) {
+		e.events.WithLabelValues(event.EventName()).Inc()
+
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		switch event := event.(type) {
+		case PointsEarned:
+			// events are handled asynchronously, the user or streamer may have been removed since
+			if !e.tracked(event.User, event.Streamer) {
+				return
+			}
+			e.pointsEarned.WithLabelValues(event.User.Username, event.Streamer.Username, event.Reason).Add(float64(event.Points))
+		case BetPlaced:
+			if !e.tracked(event.User, event.Streamer) {
+				return
+			}
+			e.betPoints.WithLabelValues(event.User.Username, event.Streamer.Username).Add(float64(event.Points))
+		case ConnectionLost:
+			e.connectionsLost.WithLabelValues(event.Kind).Inc()
+		}
+	})
+}
+
+// tracked returns whether the user still mines the streamer. Must hold the lock
+func (e *PrometheusExporter) tracked(user *User, streamer *Streamer) bool {
 	e.miner.Lock.Lock()
 	defer e.miner.Lock.Unlock()
 
+	return e.miner.Users[user.Username] == user && user.Streamers[streamer.Username] == streamer
+}
+
+// DeleteUserStreamer removes all series of the user-streamer combination
+func (e *PrometheusExporter) DeleteUserStreamer(user *User, streamer *Streamer) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.streamerPoints.DeletePartialMatch(prometheus.Labels{"username": user.Username, "streamer": streamer.Username})
+	e.pointsEarned.DeletePartialMatch(prometheus.Labels{"username": user.Username, "streamer": streamer.Username})
+	e.betPoints.DeletePartialMatch(prometheus.Labels{"username": user.Username, "streamer": streamer.Username})
+}
+
+// DeleteStreamer removes all series of the streamer
+func (e *PrometheusExporter) DeleteStreamer(streamer *Streamer) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.streamerPoints.DeletePartialMatch(prometheus.Labels{"streamer": streamer.Username})
+	e.streamerViewers.DeletePartialMatch(prometheus.Labels{"streamer": streamer.Username})
+	e.streamerLiveStatus.DeletePartialMatch(prometheus.Labels{"streamer": streamer.Username})
+	e.pointsEarned.DeletePartialMatch(prometheus.Labels{"streamer": streamer.Username})
+	e.betPoints.DeletePartialMatch(prometheus.Labels{"streamer": streamer.Username})
+}
+
+// DeleteUser removes all series of the user
+func (e *PrometheusExporter) DeleteUser(user *User) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.streamerPoints.DeletePartialMatch(prometheus.Labels{"username": user.Username})
+	e.pointsEarned.DeletePartialMatch(prometheus.Labels{"username": user.Username})
+	e.betPoints.DeletePartialMatch(prometheus.Labels{"username": user.Username})
+	e.pubsubFailedTopics.DeletePartialMatch(prometheus.Labels{"username": user.Username})
+	e.userAuth.DeleteLabelValues(user.Username)
+}
+
+func (e *PrometheusExporter) UpdateMetrics() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	streamers := e.miner.GetStreamers()
+
 	// Update total counts
-	e.totalStreamers.Set(float64(len(e.miner.Streamers)))
-	e.totalUsers.Set(float64(len(e.miner.Users)))
+	e.totalStreamers.Set(float64(len(streamers)))
+	users := e.miner.GetUsers()
+	e.totalUsers.Set(float64(len(users)))
+	for _, user := range users {
+		authOK := 1.0
+		if user.AuthError() != nil {
+			authOK = 0.0
+		}
+		e.userAuth.WithLabelValues(user.Username).Set(authOK)
+	}
 
-	for _, streamer := range e.miner.Streamers {
+	for _, streamer := range streamers {
 		// Update points for each user-streamer combination
-		for user, points := range streamer.Points {
+		for user, points := range streamer.AllPoints() {
 			e.streamerPoints.WithLabelValues(
 				user.Username,
 				streamer.Username,
@@ -110,20 +368,56 @@ func (e *PrometheusExporter) UpdateMetrics() {
 		liveStatus := 0.0
 		if streamer.IsLive() {
 			liveStatus = 1.0
-		} else {
-			// If not live, reset viewers to 0
-			streamer.Viewers = 0
 		}
 		e.streamerLiveStatus.WithLabelValues(
 			streamer.Username,
 			streamer.ID,
 		).Set(liveStatus)
 
-		// Update viewer count
+		// Update viewer count, offline streamers have 0 viewers
 		e.streamerViewers.WithLabelValues(
 			streamer.Username,
 			streamer.ID,
-		).Set(float64(streamer.Viewers))
+		).Set(float64(streamer.Viewers()))
+	}
+
+	// Update topic states of the transport
+	states := map[TopicState]int{TopicStatePending: 0, TopicStateListening: 0, TopicStateFailed: 0}
+	e.pubsubFailedTopics.Reset()
+	for _, topic := range e.miner.Transport.Topics() {
+		if topic.State == "" {
+			topic.State = TopicStatePending
+		}
+		states[topic.State]++
+		if topic.State == TopicStateFailed {
+			e.pubsubFailedTopics.WithLabelValues(
+				topic.Name,
+				topic.Username,
+				topic.Error,
+			).Set(1)
+		}
+	}
+	for state, count := range states {
+		e.pubsubTopics.WithLabelValues(state).Set(float64(count))
+	}
+
+	// Update scheduled tasks, tasks that never ran yet are left out
+	for _, task := range e.miner.Scheduler.Tasks() {
+		if task.LastRun.IsZero() {
+			continue
+		}
+		success := 1.0
+		if task.LastError != nil {
+			success = 0
+		}
+		e.taskLastRun.WithLabelValues(task.Name).Set(float64(task.LastRun.Unix()))
+		e.taskLastDuration.WithLabelValues(task.Name).Set(task.LastDuration.Seconds())
+		e.taskLastSuccess.WithLabelValues(task.Name).Set(success)
+		// the counters only grow by the runs since the last update
+		counted := e.taskCounts[task.Name]
+		e.taskRuns.WithLabelValues(task.Name).Add(float64(task.Runs - counted.Runs))
+		e.taskFailures.WithLabelValues(task.Name).Add(float64(task.Failures - counted.Failures))
+		e.taskCounts[task.Name] = task
 	}
 }
 
@@ -133,6 +427,7 @@ func (e *PrometheusExporter) Handler() http.Handler {
 	return mux
 }
 
+// StartServer serves the metrics in the background until Shutdown is called
 func (e *PrometheusExporter) StartServer(host string, port int) error {
 	e.UpdateMetrics()
 
@@ -146,5 +441,32 @@ func (e *PrometheusExporter) StartServer(host string, port int) error {
 		fmt.Printf("Metrics available at http://%s/metrics\n", addr)
 	}
 
-	return http.ListenAndServe(addr, handler)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// the server is set before serving, so a Shutdown right after StartServer stops it
+	server := &http.Server{Addr: addr, Handler: handler}
+	e.lock.Lock()
+	e.server = server
+	e.lock.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Error serving Prometheus metrics: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the server started by StartServer
+func (e *PrometheusExporter) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	server := e.server
+	e.lock.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
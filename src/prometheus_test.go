@@ -0,0 +1,65 @@
+package miner_test
+
+import (
+	"testing"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesCount returns how many series the metric has in the default registry
+func seriesCount(t *testing.T, name string) int {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return len(family.GetMetric())
+		}
+	}
+	return 0
+}
+
+func TestPrometheusIgnoresEventsOfRemovedStreamers(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}},
+	})
+	instance, _ := newTestMiner(t, server)
+	exporter, err := miner.NewPrometheusExporter(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(exporter.Unregister)
+	instance.PrometheusExporter = exporter
+	exporter.Subscribe(instance.Events)
+
+	user := instance.GetDefaultUser()
+	streamer, err := instance.AddStreamer("streamer", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.Events.Publish(miner.PointsEarned{User: user, Streamer: streamer, Points: 10, Reason: "WATCH"})
+	waitFor(t, "the earned points", func() bool { return seriesCount(t, "twitch_points_earned_total") == 1 })
+
+	if err := instance.RemoveStreamer(user, "streamer"); err != nil {
+		t.Fatal(err)
+	}
+	if got := seriesCount(t, "twitch_points_earned_total"); got != 0 {
+		t.Fatalf("removing the streamer left %d series", got)
+	}
+
+	// published before the removal was handled, like a points message arriving at the same time
+	instance.Events.Publish(miner.PointsEarned{User: user, Streamer: streamer, Points: 10, Reason: "WATCH"})
+	instance.Events.Publish(miner.BetPlaced{User: user, Streamer: streamer, Points: 10})
+	instance.Events.Close()
+	if got := seriesCount(t, "twitch_points_earned_total"); got != 0 {
+		t.Errorf("points of the removed streamer recreated %d series", got)
+	}
+	if got := seriesCount(t, "twitch_bet_points_total"); got != 0 {
+		t.Errorf("bets on the removed streamer recreated %d series", got)
+	}
+}
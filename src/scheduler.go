@@ -0,0 +1,183 @@
+package miner
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// TaskSchedule controls how often a task runs. The jitter is a random delay of up to Jitter added to every run
+type TaskSchedule struct {
+	Interval time.Duration
+	Jitter   time.Duration
+}
+
+const (
+	TaskTopics   = "topics"
+	TaskChat     = "chat"
+	TaskPoints   = "points"
+	TaskVersions = "versions"
+	TaskMetrics  = "metrics"
+	TaskFollows  = "follows"
+	TaskTokens   = "tokens"
+)
+
+func DefaultSchedule() map[string]TaskSchedule {
+	return map[string]TaskSchedule{
+		TaskTopics:   {time.Minute, 0},
+		TaskChat:     {time.Minute, 0},
+		TaskPoints:   {time.Minute, 0},
+		TaskVersions: {time.Hour, 0},
+		TaskMetrics:  {time.Minute, 0},
+		TaskFollows:  {time.Hour, 0},
+		TaskTokens:   {time.Hour, 0},
+	}
+}
+
+// taskFeatures are the tasks that are only added if a user or streamer enables the feature
+var taskFeatures = map[string]func(Options) bool{
+	TaskTopics:  Options.RequiresStreamActivity,
+	TaskChat:    func(o Options) bool { return o.MineWatchtime },
+	TaskPoints:  func(o Options) bool { return o.MinePoints },
+	TaskFollows: func(o Options) bool { return o.FollowStreamers },
+}
+
+// Task is a named job the scheduler runs periodically
+type Task struct {
+	Name     string
+	Schedule TaskSchedule
+	Run      func() error
+
+	running      bool
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    error
+	nextRun      time.Time
+	runs         int
+	failures     int
+}
+
+// TaskStatus is a snapshot of the run history of a task
+type TaskStatus struct {
+	Name         string
+	Interval     time.Duration
+	Running      bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    error
+	NextRun      time.Time
+	Runs         int
+	Failures     int
+}
+
+// Scheduler runs every task in its own goroutine, so a slow task does not delay the others.
+// A task never runs concurrently with itself, the next run is scheduled once the previous one finished.
+type Scheduler struct {
+	clock Clock
+	tasks []*Task
+	lock  sync.Mutex
+}
+
+// Add registers a task. Tasks with an interval of 0 are disabled
+func (s *Scheduler) Add(name string, schedule TaskSchedule, run func() error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tasks = append(s.tasks, &Task{Name: name, Schedule: schedule, Run: run})
+}
+
+// Run runs all tasks until ctx is cancelled and waits for running tasks to finish
+func (s *Scheduler) Run(ctx context.Context) {
+	s.lock.Lock()
+	tasks := s.tasks
+	s.lock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, task := range tasks {
+		if task.Schedule.Interval <= 0 {
+			fmt.Println("[scheduler] Task", task.Name, "is disabled")
+			continue
+		}
+		fmt.Println("[scheduler] Running", task.Name, "every", task.Schedule.Interval, "with up to", task.Schedule.Jitter, "jitter")
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runTask(ctx, task)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) runTask(ctx context.Context, task *Task) {
+	for {
+		delay := task.Schedule.Interval
+		if task.Schedule.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(task.Schedule.Jitter)))
+		}
+		s.lock.Lock()
+		task.nextRun = s.clock.Now().Add(delay)
+		s.lock.Unlock()
+
+		timer := s.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		s.lock.Lock()
+		task.running = true
+		s.lock.Unlock()
+
+		start := s.clock.Now()
+		err := task.Run()
+		duration := s.clock.Since(start)
+
+		s.lock.Lock()
+		task.running = false
+		task.lastRun = start
+		task.lastDuration = duration
+		task.lastError = err
+		task.runs++
+		if err != nil {
+			task.failures++
+		}
+		s.lock.Unlock()
+
+		if err != nil {
+			fmt.Printf("[scheduler] Task %s failed after %s: %v\n", task.Name, duration.Round(time.Millisecond), err)
+		} else if duration > task.Schedule.Interval {
+			fmt.Println("[scheduler] Task", task.Name, "took", duration.Round(time.Second), "which is longer than its interval")
+		}
+	}
+}
+
+// Tasks returns the run history of all tasks
+func (s *Scheduler) Tasks() []TaskStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		statuses = append(statuses, TaskStatus{
+			Name:         task.Name,
+			Interval:     task.Schedule.Interval,
+			Running:      task.running,
+			LastRun:      task.lastRun,
+			LastDuration: task.lastDuration,
+			LastError:    task.lastError,
+			NextRun:      task.nextRun,
+			Runs:         task.runs,
+			Failures:     task.failures,
+		})
+	}
+	return statuses
+}
+
+func NewScheduler(clock Clock) *Scheduler {
+	return &Scheduler{clock: clock, tasks: []*Task{}}
+}
@@ -0,0 +1,352 @@
+package simulator
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type prediction struct {
+	id        string
+	channel   *channel
+	title     string
+	status    string
+	createdAt time.Time
+	lockedAt  *time.Time
+	endedAt   *time.Time
+	window    int
+	outcomes  []*outcome
+	winner    *outcome
+}
+
+type outcome struct {
+	id     string
+	title  string
+	color  string
+	points int
+	users  int
+	top    int
+}
+
+// Emit applies a single event immediately and publishes it to all subscribed PubSub connections
+func (s *Server) Emit(event Event) error {
+	s.lock.Lock()
+	ch := s.channelByName(event.Channel)
+	if ch == nil && event.Type != EventChat && event.Type != EventRevoke {
+		s.lock.Unlock()
+		return fmt.Errorf("unknown channel %q", event.Channel)
+	}
+	s.lock.Unlock()
+
+	s.log("Emitting", event.Type, "for", cmp.Or(event.Channel, event.User, "everyone"))
+
+	switch event.Type {
+	case EventStreamUp:
+		s.lock.Lock()
+		ch.Live = true
+		s.lock.Unlock()
+		s.publish("video-playback-by-id."+ch.ID, map[string]any{
+			"type":        "stream-up",
+			"server_time": serverTime(),
+			"play_delay":  0,
+		})
+		s.notify("stream.online", ch.ID, map[string]any{
+			"id":                     "broadcast-" + ch.ID,
+			"broadcaster_user_id":    ch.ID,
+			"broadcaster_user_login": ch.Name,
+			"broadcaster_user_name":  ch.Name,
+			"type":                   "live",
+			"started_at":             time.Now().Format(time.RFC3339),
+		})
+	case EventStreamDown:
+		s.lock.Lock()
+		ch.Live = false
+		ch.Viewers = 0
+		s.lock.Unlock()
+		s.publish("video-playback-by-id."+ch.ID, map[string]any{
+			"type":        "stream-down",
+			"server_time": serverTime(),
+		})
+		s.notify("stream.offline", ch.ID, map[string]any{
+			"broadcaster_user_id":    ch.ID,
+			"broadcaster_user_login": ch.Name,
+			"broadcaster_user_name":  ch.Name,
+		})
+	case EventViewcount:
+		s.lock.Lock()
+		ch.Live = true
+		ch.Viewers = event.Viewers
+		s.lock.Unlock()
+		s.publish("video-playback-by-id."+ch.ID, map[string]any{
+			"type":        "viewcount",
+			"server_time": serverTime(),
+			"viewers":     event.Viewers,
+		})
+	case EventClaimAvailable:
+		s.lock.Lock()
+		users := s.usersFor(event.User)
+		s.lock.Unlock()
+		for _, u := range users {
+			s.publish("community-points-user-v1."+u.ID, map[string]any{
+				"type": "claim-available",
+				"data": map[string]any{
+					"timestamp": time.Now().Format(time.RFC3339),
+					"claim": map[string]any{
+						"id":         claimID(ch, u),
+						"user_id":    u.ID,
+						"channel_id": ch.ID,
+					},
+				},
+			})
+		}
+	case EventPointsEarned:
+		reason := event.Reason
+		if reason == "" {
+			reason = "WATCH"
+		}
+		s.lock.Lock()
+		users := s.usersFor(event.User)
+		s.lock.Unlock()
+		for _, u := range users {
+			s.earnPoints(ch, u, event.Points, reason)
+		}
+	case EventPredictionCreated:
+		pred := &prediction{
+			id:        event.ID,
+			channel:   ch,
+			title:     event.Title,
+			status:    "ACTIVE",
+			createdAt: time.Now(),
+			window:    event.Window,
+		}
+		if pred.id == "" {
+			pred.id = fmt.Sprintf("prediction-%d", time.Now().UnixNano())
+		}
+		if pred.window == 0 {
+			pred.window = 120
+		}
+		colors := []string{"BLUE", "PINK"}
+		for i, title := range event.Outcomes {
+			o := &outcome{
+				id:    fmt.Sprintf("%s-outcome-%d", pred.id, i),
+				title: title,
+				color: colors[min(i, len(colors)-1)],
+			}
+			if i < len(event.OutcomePoints) {
+				o.points = event.OutcomePoints[i]
+				o.top = o.points / 4
+				if o.points > 0 {
+					o.users = max(1, o.points/1_000)
+				}
+			}
+			pred.outcomes = append(pred.outcomes, o)
+		}
+		s.lock.Lock()
+		s.predictions[pred.id] = pred
+		s.lock.Unlock()
+		s.publishPrediction(pred, "event-created")
+	case EventPredictionLocked, EventPredictionResolve:
+		s.lock.Lock()
+		pred, ok := s.predictions[event.ID]
+		if !ok {
+			s.lock.Unlock()
+			return fmt.Errorf("unknown prediction %q", event.ID)
+		}
+		now := time.Now()
+		if pred.lockedAt == nil {
+			pred.lockedAt = &now
+		}
+		pred.status = "LOCKED"
+		if event.Type == EventPredictionResolve {
+			pred.status = "RESOLVED"
+			pred.endedAt = &now
+			for _, o := range pred.outcomes {
+				if o.title == event.Winner {
+					pred.winner = o
+				}
+			}
+			if pred.winner == nil {
+				s.lock.Unlock()
+				return fmt.Errorf("prediction %q has no outcome %q", event.ID, event.Winner)
+			}
+			delete(s.predictions, pred.id)
+		}
+		s.lock.Unlock()
+		s.publishPrediction(pred, "event-updated")
+	case EventRaid:
+		s.lock.Lock()
+		target := s.channelByName(event.Target)
+		viewers := ch.Viewers
+		s.lock.Unlock()
+		s.publish("raid."+ch.ID, map[string]any{
+			"type": "raid_update_v2",
+			"raid": map[string]any{
+				"id":                        fmt.Sprintf("raid-%s-%s", ch.ID, target.ID),
+				"creator_id":                ch.ID,
+				"source_id":                 ch.ID,
+				"target_id":                 target.ID,
+				"target_login":              target.Name,
+				"target_display_name":       target.Name,
+				"transition_jitter_seconds": 5,
+				"force_raid_now_seconds":    90,
+				"viewer_count":              viewers,
+			},
+		})
+		s.notify("channel.raid", ch.ID, map[string]any{
+			"from_broadcaster_user_id":    ch.ID,
+			"from_broadcaster_user_login": ch.Name,
+			"from_broadcaster_user_name":  ch.Name,
+			"to_broadcaster_user_id":      target.ID,
+			"to_broadcaster_user_login":   target.Name,
+			"to_broadcaster_user_name":    target.Name,
+			"viewers":                     viewers,
+		})
+	case EventChat:
+		s.sendChat(event)
+	case EventFollow, EventUnfollow:
+		s.lock.Lock()
+		for _, u := range s.usersFor(event.User) {
+			u.follows[ch.Name] = event.Type == EventFollow
+		}
+		s.lock.Unlock()
+	case EventRevoke:
+		s.lock.Lock()
+		for _, u := range s.usersFor(event.User) {
+			u.revoked = true
+		}
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+func (s *Server) earnPoints(ch *channel, u *user, points int, reason string) {
+	s.lock.Lock()
+	ch.points[u] += points
+	balance := ch.points[u]
+	s.lock.Unlock()
+
+	s.publish("community-points-user-v1."+u.ID, map[string]any{
+		"type": "points-earned",
+		"data": map[string]any{
+			"timestamp":  time.Now().Format(time.RFC3339),
+			"channel_id": ch.ID,
+			"point_gain": map[string]any{
+				"user_id":      u.ID,
+				"channel_id":   ch.ID,
+				"total_points": points,
+				"base_points":  points,
+				"reason_code":  reason,
+			},
+			"balance": map[string]any{
+				"user_id":    u.ID,
+				"channel_id": ch.ID,
+				"balance":    balance,
+			},
+		},
+	})
+}
+
+func (s *Server) spendPoints(ch *channel, u *user, points int) {
+	s.lock.Lock()
+	ch.points[u] -= points
+	balance := ch.points[u]
+	s.lock.Unlock()
+
+	s.publish("community-points-user-v1."+u.ID, map[string]any{
+		"type": "points-spent",
+		"data": map[string]any{
+			"timestamp": time.Now().Format(time.RFC3339),
+			"balance": map[string]any{
+				"user_id":    u.ID,
+				"channel_id": ch.ID,
+				"balance":    balance,
+			},
+		},
+	})
+}
+
+func (s *Server) publishPrediction(pred *prediction, messageType string) {
+	s.lock.Lock()
+	outcomes := []map[string]any{}
+	eventSubOutcomes := []map[string]any{}
+	for _, o := range pred.outcomes {
+		eventSubPredictors := []map[string]any{}
+		if o.top > 0 {
+			eventSubPredictors = append(eventSubPredictors, map[string]any{"channel_points_used": o.top})
+		}
+		eventSubOutcomes = append(eventSubOutcomes, map[string]any{
+			"id":             o.id,
+			"title":          o.title,
+			"color":          strings.ToLower(o.color),
+			"users":          o.users,
+			"channel_points": o.points,
+			"top_predictors": eventSubPredictors,
+		})
+		topPredictors := []map[string]any{}
+		if o.top > 0 {
+			topPredictors = append(topPredictors, map[string]any{"points": o.top})
+		}
+		outcomes = append(outcomes, map[string]any{
+			"id":             o.id,
+			"color":          o.color,
+			"title":          o.title,
+			"total_points":   o.points,
+			"total_users":    o.users,
+			"top_predictors": topPredictors,
+		})
+	}
+	event := map[string]any{
+		"id":                        pred.id,
+		"channel_id":                pred.channel.ID,
+		"created_at":                pred.createdAt.Format(time.RFC3339Nano),
+		"ended_at":                  pred.endedAt,
+		"locked_at":                 pred.lockedAt,
+		"prediction_window_seconds": pred.window,
+		"status":                    pred.status,
+		"title":                     pred.title,
+		"outcomes":                  outcomes,
+		"winning_outcome_id":        nil,
+	}
+	if pred.winner != nil {
+		event["winning_outcome_id"] = pred.winner.id
+	}
+	channelID := pred.channel.ID
+	eventSubEvent := map[string]any{
+		"id":                     pred.id,
+		"broadcaster_user_id":    channelID,
+		"broadcaster_user_login": pred.channel.Name,
+		"title":                  pred.title,
+		"outcomes":               eventSubOutcomes,
+		"started_at":             pred.createdAt.Format(time.RFC3339Nano),
+		"locks_at":               pred.createdAt.Add(time.Duration(pred.window) * time.Second).Format(time.RFC3339Nano),
+		"locked_at":              pred.lockedAt,
+		"ended_at":               pred.endedAt,
+		"winning_outcome_id":     event["winning_outcome_id"],
+		"status":                 strings.ToLower(pred.status),
+	}
+	subscriptionType := "channel.prediction.progress"
+	switch {
+	case messageType == "event-created":
+		subscriptionType = "channel.prediction.begin"
+	case pred.status == "LOCKED":
+		subscriptionType = "channel.prediction.lock"
+	case pred.status == "RESOLVED":
+		subscriptionType = "channel.prediction.end"
+	}
+	s.lock.Unlock()
+
+	s.notify(subscriptionType, channelID, eventSubEvent)
+
+	s.publish("predictions-channel-v1."+channelID, map[string]any{
+		"type": messageType,
+		"data": map[string]any{
+			"timestamp": time.Now().Format(time.RFC3339),
+			"event":     event,
+		},
+	})
+}
+
+func claimID(ch *channel, u *user) string {
+	return fmt.Sprintf("claim-%s-%s-%d", ch.ID, u.ID, time.Now().UnixNano())
+}
@@ -0,0 +1,215 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	eventSubKeepalive = 10 * time.Second
+	// like Twitch, a websocket session may only hold subscriptions up to this cost
+	eventSubMaxTotalCost = 10
+)
+
+type eventSubConn struct {
+	conn      *websocket.Conn
+	sessionID string
+	lock      sync.Mutex
+}
+
+type eventSubSubscription struct {
+	id        string
+	kind      string
+	condition map[string]string
+	sessionID string
+	user      *user
+}
+
+func (s *Server) handleEventSub(ws *websocket.Conn) {
+	s.lock.Lock()
+	s.eventSubSessions++
+	conn := &eventSubConn{conn: ws, sessionID: fmt.Sprintf("sim-session-%d", s.eventSubSessions)}
+	s.eventsub = append(s.eventsub, conn)
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.eventsub = slices.DeleteFunc(s.eventsub, func(c *eventSubConn) bool { return c == conn })
+		// subscriptions die with their session
+		s.eventSubSubscriptions = slices.DeleteFunc(s.eventSubSubscriptions, func(sub *eventSubSubscription) bool {
+			return sub.sessionID == conn.sessionID
+		})
+		s.lock.Unlock()
+		_ = ws.Close()
+	}()
+
+	conn.send("session_welcome", "", map[string]any{
+		"session": map[string]any{
+			"id":                        conn.sessionID,
+			"status":                    "connected",
+			"keepalive_timeout_seconds": int(eventSubKeepalive.Seconds()),
+			"reconnect_url":             nil,
+			"connected_at":              time.Now().Format(time.RFC3339Nano),
+		},
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(eventSubKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				conn.send("session_keepalive", "", map[string]any{})
+			}
+		}
+	}()
+
+	// clients never send anything, reading only detects the disconnect
+	for {
+		var ignored any
+		if err := websocket.JSON.Receive(ws, &ignored); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handleHelixCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	u := s.userByToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if u == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "Unauthorized", "status": 401, "message": "Invalid OAuth token"})
+		return
+	}
+
+	var request struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport struct {
+			Method    string `json:"method"`
+			SessionID string `json:"session_id"`
+		} `json:"transport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Transport.Method != "websocket" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Bad Request", "status": 400, "message": "invalid request"})
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.calls["helix:"+request.Type]++
+	if !slices.ContainsFunc(s.eventsub, func(c *eventSubConn) bool { return c.sessionID == request.Transport.SessionID }) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Bad Request", "status": 400, "message": "session does not exist or has already disconnected"})
+		return
+	}
+	broadcaster := request.Condition["broadcaster_user_id"] + request.Condition["from_broadcaster_user_id"]
+	if s.channelByID(broadcaster) == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Bad Request", "status": 400, "message": "unknown broadcaster"})
+		return
+	}
+	cost := 0
+	for _, sub := range s.eventSubSubscriptions {
+		if sub.sessionID == request.Transport.SessionID {
+			cost++
+		}
+	}
+	if cost >= eventSubMaxTotalCost {
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "Too Many Requests", "status": 429, "message": "number of subscriptions exceeds maximum allowed"})
+		return
+	}
+
+	s.eventSubIDs++
+	sub := &eventSubSubscription{
+		id:        fmt.Sprintf("sim-subscription-%d", s.eventSubIDs),
+		kind:      request.Type,
+		condition: request.Condition,
+		sessionID: request.Transport.SessionID,
+		user:      u,
+	}
+	s.eventSubSubscriptions = append(s.eventSubSubscriptions, sub)
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"data":           []map[string]any{{"id": sub.id, "type": sub.kind, "status": "enabled"}},
+		"total":          len(s.eventSubSubscriptions),
+		"total_cost":     cost + 1,
+		"max_total_cost": eventSubMaxTotalCost,
+	})
+}
+
+func (s *Server) handleHelixDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if s.userByToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "Unauthorized", "status": 401, "message": "Invalid OAuth token"})
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	index := slices.IndexFunc(s.eventSubSubscriptions, func(sub *eventSubSubscription) bool { return sub.id == id })
+	if index == -1 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "Not Found", "status": 404, "message": "subscription not found"})
+		return
+	}
+	s.eventSubSubscriptions = slices.Delete(s.eventSubSubscriptions, index, index+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notify sends a notification to every session subscribed to the type for the broadcaster
+func (s *Server) notify(subscriptionType string, broadcasterID string, event map[string]any) {
+	s.lock.Lock()
+	targets := map[*eventSubConn]*eventSubSubscription{}
+	for _, sub := range s.eventSubSubscriptions {
+		if sub.kind != subscriptionType {
+			continue
+		}
+		if sub.condition["broadcaster_user_id"] != broadcasterID && sub.condition["from_broadcaster_user_id"] != broadcasterID {
+			continue
+		}
+		for _, conn := range s.eventsub {
+			if conn.sessionID == sub.sessionID {
+				targets[conn] = sub
+			}
+		}
+	}
+	s.lock.Unlock()
+
+	for conn, sub := range targets {
+		conn.send("notification", subscriptionType, map[string]any{
+			"subscription": map[string]any{
+				"id":        sub.id,
+				"type":      sub.kind,
+				"version":   "1",
+				"status":    "enabled",
+				"condition": sub.condition,
+			},
+			"event": event,
+		})
+	}
+}
+
+func (c *eventSubConn) send(messageType string, subscriptionType string, payload any) {
+	metadata := map[string]any{
+		"message_id":        fmt.Sprintf("sim-%d", time.Now().UnixNano()),
+		"message_type":      messageType,
+		"message_timestamp": time.Now().Format(time.RFC3339Nano),
+	}
+	if subscriptionType != "" {
+		metadata["subscription_type"] = subscriptionType
+		metadata["subscription_version"] = "1"
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_ = websocket.JSON.Send(c.conn, map[string]any{"metadata": metadata, "payload": payload})
+}
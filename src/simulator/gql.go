@@ -0,0 +1,170 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type graphQLRequest struct {
+	OperationName string         `json:"operationName"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	u := s.userByToken(strings.TrimPrefix(r.Header.Get("Authorization"), "OAuth "))
+	if u == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{
+			"error":   "Unauthorized",
+			"status":  http.StatusUnauthorized,
+			"message": "The \"Authorization\" token is invalid.",
+		})
+		return
+	}
+
+	var req graphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Bad Request", "message": err.Error()})
+		return
+	}
+
+	operation := req.OperationName
+	if operation == "" && strings.Contains(req.Query, "sendSpadeEvents") {
+		operation = "SendEvents"
+	}
+	s.lock.Lock()
+	s.calls[operation]++
+	s.lock.Unlock()
+
+	response, err := s.graphQLOperation(u, operation, req.Variables)
+	if err != nil {
+		s.log("GraphQL", operation, "failed:", err)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"errors": []map[string]any{{"message": err.Error()}},
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": response})
+}
+
+func (s *Server) graphQLOperation(u *user, operation string, variables map[string]any) (any, error) {
+	input, _ := variables["input"].(map[string]any)
+
+	switch operation {
+	case "GetIDFromLogin":
+		login, _ := variables["login"].(string)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if ch := s.channelByName(login); ch != nil {
+			return map[string]any{"user": map[string]any{"id": ch.ID, "login": ch.Name}}, nil
+		}
+		if other := s.userByName(login); other != nil {
+			return map[string]any{"user": map[string]any{"id": other.ID, "login": other.Name}}, nil
+		}
+		return map[string]any{"user": nil}, nil
+	case "ChannelFollows":
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		edges := []map[string]any{}
+		for i, ch := range s.channels {
+			if !u.follows[ch.Name] {
+				continue
+			}
+			edges = append(edges, map[string]any{
+				"cursor": fmt.Sprintf("%d", i),
+				"node":   map[string]any{"id": ch.ID, "login": ch.Name},
+			})
+		}
+		return map[string]any{"user": map[string]any{"follows": map[string]any{
+			"edges":    edges,
+			"pageInfo": map[string]any{"hasNextPage": false},
+		}}}, nil
+	case "ChannelPointsContext":
+		login, _ := variables["channelLogin"].(string)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		ch := s.channelByName(login)
+		if ch == nil {
+			return nil, fmt.Errorf("channel %q not found", login)
+		}
+		return map[string]any{"community": map[string]any{"channel": map[string]any{"self": map[string]any{
+			"communityPoints": map[string]any{
+				"balance":        ch.points[u],
+				"availableClaim": nil,
+			},
+		}}}}, nil
+	case "ClaimCommunityPoints":
+		channelID, _ := input["channelID"].(string)
+		s.lock.Lock()
+		ch := s.channelByID(channelID)
+		s.lock.Unlock()
+		if ch == nil {
+			return nil, fmt.Errorf("channel %q not found", channelID)
+		}
+		s.log(u.Name, "claimed bonus on", ch.Name)
+		s.earnPoints(ch, u, 50, "CLAIM")
+		return map[string]any{"claimCommunityPoints": map[string]any{"error": nil}}, nil
+	case "MakePrediction":
+		eventID, _ := input["eventID"].(string)
+		outcomeID, _ := input["outcomeID"].(string)
+		points, _ := input["points"].(float64)
+		s.lock.Lock()
+		pred, ok := s.predictions[eventID]
+		if !ok || pred.status != "ACTIVE" {
+			s.lock.Unlock()
+			return map[string]any{"makePrediction": map[string]any{"error": map[string]any{"code": "EVENT_NOT_ACTIVE"}}}, nil
+		}
+		var chosen *outcome
+		for _, o := range pred.outcomes {
+			if o.id == outcomeID {
+				chosen = o
+			}
+		}
+		if chosen == nil || int(points) > pred.channel.points[u] {
+			s.lock.Unlock()
+			return map[string]any{"makePrediction": map[string]any{"error": map[string]any{"code": "INVALID_BET"}}}, nil
+		}
+		chosen.points += int(points)
+		chosen.users++
+		chosen.top = max(chosen.top, int(points))
+		ch := pred.channel
+		s.lock.Unlock()
+
+		s.log(u.Name, "bet", int(points), "points on", chosen.title, "for", pred.title)
+		s.spendPoints(ch, u, int(points))
+		s.publishPrediction(pred, "event-updated")
+		return map[string]any{"makePrediction": map[string]any{"error": nil}}, nil
+	case "JoinRaid":
+		raidID, _ := input["raidID"].(string)
+		s.log(u.Name, "joined raid", raidID)
+		return map[string]any{"joinRaid": map[string]any{"raidID": raidID}}, nil
+	case "PlaybackAccessToken":
+		login, _ := variables["login"].(string)
+		return map[string]any{"streamPlaybackAccessToken": map[string]any{
+			"signature": "simsignature",
+			"value":     fmt.Sprintf(`{"channel":"%s","user_id":"%s"}`, login, u.ID),
+		}}, nil
+	case "VideoPlayerStreamInfoOverlayChannel":
+		login, _ := variables["channel"].(string)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		ch := s.channelByName(login)
+		if ch == nil || !ch.Live {
+			return map[string]any{"user": map[string]any{"stream": nil}}, nil
+		}
+		return map[string]any{"user": map[string]any{"stream": map[string]any{"id": "broadcast-" + ch.ID, "viewersCount": ch.Viewers}}}, nil
+	case "CommunityMomentCallout_Claim":
+		return map[string]any{"claimCommunityMoment": map[string]any{"error": nil}}, nil
+	case "SendEvents":
+		return map[string]any{"sendSpadeEvents": map[string]any{"statusCode": 204}}, nil
+	}
+	return nil, fmt.Errorf("PersistedQueryNotFound")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
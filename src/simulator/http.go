@@ -0,0 +1,147 @@
+package simulator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// how many minute-watched events are needed for the watch reward (Twitch pays 10 points every 5 minutes)
+const minutesPerWatchReward = 5
+
+func (s *Server) handleWebsite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	_, _ = fmt.Fprintf(w, `<!DOCTYPE html><html><head><script>window.__twilightBuildID="simulator";</script>`+
+		`<script src="http://%s/config/settings.simulator.js"></script></head><body></body></html>`, r.Host)
+}
+
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	_, _ = fmt.Fprintf(w, `window.__twilightSettings = {"spade_url":"http://%s/spade"};`, r.Host)
+}
+
+func (s *Server) handleUsher(w http.ResponseWriter, r *http.Request) {
+	login := strings.TrimSuffix(r.PathValue("file"), ".m3u8")
+	s.lock.Lock()
+	ch := s.channelByName(login)
+	live := ch != nil && ch.Live
+	s.lock.Unlock()
+
+	if !live {
+		http.Error(w, `[{"error":"twirp error not_found: transcode does not exist","error_code":"transcode_does_not_exist"}]`, http.StatusNotFound)
+		return
+	}
+
+	// the miner uses the last line as the lowest quality, so no trailing newline
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	_, _ = fmt.Fprintf(w, "#EXTM3U\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080\nhttp://%[1]s/hls/%[2]s/1080p.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=230000,RESOLUTION=284x160\nhttp://%[1]s/hls/%[2]s/160p.m3u8", r.Host, login)
+}
+
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	_, _ = fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.000,live\nsegment.ts\n")
+}
+
+func (s *Server) handleSpade(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(r.PostForm.Get("data"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var events []struct {
+		Event      string `json:"event"`
+		Properties struct {
+			ChannelID string `json:"channel_id"`
+			UserID    string `json:"user_id"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(decoded, &events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		if event.Event != "minute-watched" {
+			continue
+		}
+
+		s.lock.Lock()
+		s.calls["minute-watched"]++
+		ch := s.channelByID(event.Properties.ChannelID)
+		var u *user
+		for _, candidate := range s.users {
+			if candidate.ID == event.Properties.UserID {
+				u = candidate
+			}
+		}
+		reward := false
+		if ch != nil && u != nil && ch.Live {
+			ch.watched[u]++
+			reward = ch.watched[u]%minutesPerWatchReward == 0
+		}
+		s.lock.Unlock()
+
+		if reward {
+			s.earnPoints(ch, u, 10, "WATCH")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"device_code":      "simdevicecode",
+		"expires_in":       1800,
+		"interval":         1,
+		"user_code":        "SIMULATE",
+		"verification_uri": "http://" + r.Host + "/activate",
+	})
+}
+
+// handleToken authorizes the device immediately as the first user of the script, whose revoked token is replaced by a new one
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.users) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"status": 400, "message": "authorization_pending"})
+		return
+	}
+	if u := s.users[0]; u.revoked {
+		u.revoked = false
+		u.Token += "-renewed"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": s.users[0].Token,
+		"expires_in":   14400,
+		"scope":        s.users[0].Scopes,
+		"token_type":   "bearer",
+	})
+}
+
+// handleValidate describes the token like https://dev.twitch.tv/docs/authentication/validate-tokens
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	token = strings.TrimPrefix(strings.TrimPrefix(token, "OAuth "), "Bearer ")
+	u := s.userByToken(token)
+	if u == nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"status": 401, "message": "invalid access token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"client_id":  "simclient",
+		"login":      u.Name,
+		"scopes":     u.Scopes,
+		"user_id":    u.ID,
+		"expires_in": 0,
+	})
+}
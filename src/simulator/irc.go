@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/irc.v4"
+)
+
+type ircConn struct {
+	conn     net.Conn
+	nick     string
+	pass     string
+	channels []string
+	lock     sync.Mutex
+}
+
+func (s *Server) serveIRC() {
+	for {
+		conn, err := s.ircListener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log("IRC listener stopped:", err)
+			}
+			return
+		}
+		go s.handleIRC(conn)
+	}
+}
+
+func (s *Server) handleIRC(netConn net.Conn) {
+	conn := &ircConn{conn: netConn}
+	s.lock.Lock()
+	s.irc = append(s.irc, conn)
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.irc = slices.DeleteFunc(s.irc, func(c *ircConn) bool { return c == conn })
+		s.lock.Unlock()
+		_ = netConn.Close()
+	}()
+
+	reader := irc.NewReader(netConn)
+	for {
+		message, err := reader.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch message.Command {
+		case "CAP":
+			if len(message.Params) > 0 && message.Params[0] == "REQ" {
+				conn.write(":tmi.twitch.tv CAP * ACK :" + message.Trailing())
+			}
+		case "PASS":
+			conn.pass = strings.TrimPrefix(message.Trailing(), "oauth:")
+		case "NICK":
+			conn.nick = message.Trailing()
+			u := s.userByToken(conn.pass)
+			if u == nil || u.Name != conn.nick {
+				conn.write(":tmi.twitch.tv NOTICE * :Login authentication failed")
+				return
+			}
+			for _, line := range []string{
+				"001 %[1]s :Welcome, GLHF!",
+				"002 %[1]s :Your host is tmi.twitch.tv",
+				"003 %[1]s :This server is rather new",
+				"004 %[1]s :-",
+				"375 %[1]s :-",
+				"372 %[1]s :You are in a maze of twisty passages, all alike.",
+				"376 %[1]s :>",
+			} {
+				conn.write(":tmi.twitch.tv " + fmt.Sprintf(line, conn.nick))
+			}
+		case "PING":
+			conn.write(":tmi.twitch.tv PONG tmi.twitch.tv :" + message.Trailing())
+		case "JOIN", "PART":
+			if len(message.Params) == 0 {
+				continue
+			}
+			for _, channel := range strings.Split(message.Params[0], ",") {
+				conn.lock.Lock()
+				if message.Command == "JOIN" {
+					conn.channels = append(conn.channels, channel)
+				} else {
+					conn.channels = slices.DeleteFunc(conn.channels, func(c string) bool { return c == channel })
+				}
+				conn.lock.Unlock()
+				conn.write(fmt.Sprintf(":%[1]s!%[1]s@%[1]s.tmi.twitch.tv %s %s", conn.nick, message.Command, channel))
+			}
+		case "PRIVMSG":
+			s.log(conn.nick, "wrote in", message.Params[0]+":", message.Trailing())
+		}
+	}
+}
+
+// sendChat delivers a chat message to every connection that joined the channel
+func (s *Server) sendChat(event Event) {
+	channel := "#" + event.Channel
+	subscriber := "0"
+	if event.Subscriber {
+		subscriber = "1"
+	}
+	sender := event.Sender
+	if sender == "" {
+		sender = "simviewer"
+	}
+
+	message := &irc.Message{
+		Tags:    irc.Tags{"subscriber": subscriber, "display-name": sender},
+		Prefix:  &irc.Prefix{Name: sender, User: sender, Host: sender + ".tmi.twitch.tv"},
+		Command: "PRIVMSG",
+		Params:  []string{channel, event.Message},
+	}
+
+	s.lock.Lock()
+	conns := slices.Clone(s.irc)
+	s.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.lock.Lock()
+		joined := slices.Contains(conn.channels, channel)
+		conn.lock.Unlock()
+		if joined {
+			conn.write(message.String())
+		}
+	}
+}
+
+func (c *ircConn) write(line string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, _ = c.conn.Write([]byte(line + "\r\n"))
+}
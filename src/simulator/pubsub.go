@@ -0,0 +1,140 @@
+package simulator
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+type pubsubConn struct {
+	conn   *websocket.Conn
+	topics []string
+	lock   sync.Mutex
+}
+
+type pubsubRequest struct {
+	Type  string `json:"type"`
+	Nonce string `json:"nonce"`
+	Data  struct {
+		Topics    []string `json:"topics"`
+		AuthToken string   `json:"auth_token"`
+	} `json:"data"`
+}
+
+func (s *Server) handlePubSub(ws *websocket.Conn) {
+	conn := &pubsubConn{conn: ws}
+	s.lock.Lock()
+	s.pubsub = append(s.pubsub, conn)
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.pubsub = slices.DeleteFunc(s.pubsub, func(c *pubsubConn) bool { return c == conn })
+		s.lock.Unlock()
+		_ = ws.Close()
+	}()
+
+	for {
+		var request pubsubRequest
+		if err := websocket.JSON.Receive(ws, &request); err != nil {
+			return
+		}
+
+		switch request.Type {
+		case "PING":
+			conn.send(map[string]any{"type": "PONG"})
+		case "LISTEN":
+			if errorCode := s.authorizeTopics(request.Data.Topics, request.Data.AuthToken); errorCode != "" {
+				s.log("Rejecting LISTEN", request.Data.Topics, errorCode)
+				conn.send(map[string]any{"type": "RESPONSE", "nonce": request.Nonce, "error": errorCode})
+				continue
+			}
+			conn.lock.Lock()
+			for _, topic := range request.Data.Topics {
+				if !slices.Contains(conn.topics, topic) {
+					conn.topics = append(conn.topics, topic)
+				}
+			}
+			conn.lock.Unlock()
+			conn.send(map[string]any{"type": "RESPONSE", "nonce": request.Nonce, "error": ""})
+		case "UNLISTEN":
+			conn.lock.Lock()
+			conn.topics = slices.DeleteFunc(conn.topics, func(topic string) bool {
+				return slices.Contains(request.Data.Topics, topic)
+			})
+			conn.lock.Unlock()
+			conn.send(map[string]any{"type": "RESPONSE", "nonce": request.Nonce, "error": ""})
+		default:
+			conn.send(map[string]any{"type": "RESPONSE", "nonce": request.Nonce, "error": "ERR_BADMESSAGE"})
+		}
+	}
+}
+
+// authorizeTopics mimics Twitch: user topics need the token of that user, unknown topics are rejected
+func (s *Server) authorizeTopics(topics []string, token string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, topic := range topics {
+		name, id, ok := strings.Cut(topic, ".")
+		if !ok {
+			return "ERR_BADTOPIC"
+		}
+		switch name {
+		case "community-points-user-v1", "predictions-user-v1":
+			authorized := false
+			for _, u := range s.users {
+				if u.ID == id && u.Token == token {
+					authorized = true
+				}
+			}
+			if !authorized {
+				return "ERR_BADAUTH"
+			}
+		case "video-playback-by-id", "raid", "predictions-channel-v1", "community-moments-channel-v1":
+			if s.channelByID(id) == nil {
+				return "ERR_BADTOPIC"
+			}
+		default:
+			return "ERR_BADTOPIC"
+		}
+	}
+	return ""
+}
+
+// publish sends a message to every connection listening on the topic
+func (s *Server) publish(topic string, message any) {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		s.log("Failed to encode message", err)
+		return
+	}
+
+	s.lock.Lock()
+	conns := slices.Clone(s.pubsub)
+	s.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.lock.Lock()
+		listening := slices.Contains(conn.topics, topic)
+		conn.lock.Unlock()
+		if listening {
+			conn.send(map[string]any{
+				"type": "MESSAGE",
+				"data": map[string]any{
+					"topic":   topic,
+					"message": string(encoded),
+				},
+			})
+		}
+	}
+}
+
+func (c *pubsubConn) send(message any) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_ = websocket.JSON.Send(c.conn, message)
+}
@@ -0,0 +1,200 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Script describes the fake Twitch world: which accounts and channels exist and which events happen when
+type Script struct {
+	Users    []ScriptUser    `yaml:"users"`
+	Channels []ScriptChannel `yaml:"channels"`
+	Events   []Event         `yaml:"events"`
+}
+
+type ScriptUser struct {
+	Name  string `yaml:"name"`
+	ID    string `yaml:"id"`
+	Token string `yaml:"token"`
+	// Follows lists the channels this user follows. Empty means every channel
+	Follows []string `yaml:"follows"`
+	// Scopes the token was granted, defaults to the scopes the login requests
+	Scopes []string `yaml:"scopes"`
+}
+
+type ScriptChannel struct {
+	Name string `yaml:"name"`
+	ID   string `yaml:"id"`
+	// Points is the starting balance of every user on this channel
+	Points int `yaml:"points"`
+	// Live marks the channel as live from the start
+	Live    bool `yaml:"live"`
+	Viewers int  `yaml:"viewers"`
+}
+
+// defaultScopes are the scopes the login of the miner requests
+var defaultScopes = []string{"channel_read", "chat:read", "user_blocks_edit", "user_blocks_read", "user_follows_edit", "user_read"}
+
+type EventType = string
+
+const (
+	EventStreamUp          EventType = "stream-up"
+	EventStreamDown        EventType = "stream-down"
+	EventViewcount         EventType = "viewcount"
+	EventClaimAvailable    EventType = "claim-available"
+	EventPointsEarned      EventType = "points-earned"
+	EventPredictionCreated EventType = "prediction-created"
+	EventPredictionLocked  EventType = "prediction-locked"
+	EventPredictionResolve EventType = "prediction-resolved"
+	EventRaid              EventType = "raid"
+	EventChat              EventType = "chat"
+	EventFollow            EventType = "follow"
+	EventUnfollow          EventType = "unfollow"
+	EventRevoke            EventType = "revoke"
+)
+
+// Event is a single entry on the timeline. Which fields are used depends on the type
+type Event struct {
+	// At is the offset from the start of the timeline
+	At      time.Duration `yaml:"at"`
+	Type    EventType     `yaml:"type"`
+	Channel string        `yaml:"channel"`
+	// User is the account the event targets, empty means all accounts
+	User    string `yaml:"user"`
+	Viewers int    `yaml:"viewers"`
+	Points  int    `yaml:"points"`
+	Reason  string `yaml:"reason"`
+
+	// prediction-*: ID links the created, locked and resolved events together
+	ID       string   `yaml:"id"`
+	Title    string   `yaml:"title"`
+	Outcomes []string `yaml:"outcomes"`
+	// OutcomePoints are the points already bet on each outcome by other viewers
+	OutcomePoints []int  `yaml:"outcome_points"`
+	Window        int    `yaml:"window"`
+	Winner        string `yaml:"winner"`
+
+	// raid
+	Target string `yaml:"target"`
+
+	// chat
+	Sender     string `yaml:"sender"`
+	Message    string `yaml:"message"`
+	Subscriber bool   `yaml:"subscriber"`
+}
+
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var script Script
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	if err := script.validate(); err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+// NewScript validates a script built in code and fills in missing IDs and tokens
+func NewScript(script *Script) (*Script, error) {
+	if err := script.validate(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func (s *Script) validate() error {
+	channels := map[string]bool{}
+	for i, channel := range s.Channels {
+		if channel.Name == "" {
+			return fmt.Errorf("channel %d has no name", i)
+		}
+		if channel.ID == "" {
+			s.Channels[i].ID = fmt.Sprintf("%d", 2000+i)
+		}
+		channels[channel.Name] = true
+	}
+	users := map[string]bool{}
+	for i, user := range s.Users {
+		if user.Name == "" {
+			return fmt.Errorf("user %d has no name", i)
+		}
+		if user.ID == "" {
+			s.Users[i].ID = fmt.Sprintf("%d", 1000+i)
+		}
+		if user.Token == "" {
+			s.Users[i].Token = "sim-" + user.Name
+		}
+		if user.Scopes == nil {
+			s.Users[i].Scopes = defaultScopes
+		}
+		users[user.Name] = true
+	}
+	for i, event := range s.Events {
+		if event.Channel != "" && !channels[event.Channel] {
+			return fmt.Errorf("event %d (%s) references unknown channel %s", i, event.Type, event.Channel)
+		}
+		if event.User != "" && !users[event.User] {
+			return fmt.Errorf("event %d (%s) references unknown user %s", i, event.Type, event.User)
+		}
+		switch event.Type {
+		case EventStreamUp, EventStreamDown, EventViewcount, EventClaimAvailable, EventPointsEarned, EventChat, EventFollow, EventUnfollow:
+		case EventRevoke:
+			if event.Channel != "" {
+				return fmt.Errorf("event %d (%s) does not take a channel", i, event.Type)
+			}
+		case EventPredictionCreated:
+			if len(event.Outcomes) < 2 {
+				return fmt.Errorf("event %d (%s) needs at least 2 outcomes", i, event.Type)
+			}
+		case EventPredictionLocked, EventPredictionResolve:
+			if event.ID == "" {
+				return fmt.Errorf("event %d (%s) needs the id of the prediction", i, event.Type)
+			}
+		case EventRaid:
+			if !channels[event.Target] {
+				return fmt.Errorf("event %d (%s) references unknown target %s", i, event.Type, event.Target)
+			}
+		default:
+			return fmt.Errorf("event %d has unknown type %q", i, event.Type)
+		}
+	}
+	return nil
+}
+
+// DefaultScript is used when no script is given. One user, two channels and a bit of everything
+func DefaultScript() *Script {
+	script := &Script{
+		Users: []ScriptUser{
+			{Name: "simuser", ID: "1000", Token: "sim-simuser"},
+		},
+		Channels: []ScriptChannel{
+			{Name: "simstreamer", ID: "2000", Points: 5_000},
+			{Name: "simraider", ID: "2001", Points: 20_000, Live: true, Viewers: 1_500},
+		},
+		Events: []Event{
+			{At: 5 * time.Second, Type: EventStreamUp, Channel: "simstreamer"},
+			{At: 10 * time.Second, Type: EventViewcount, Channel: "simstreamer", Viewers: 250},
+			{At: 90 * time.Second, Type: EventClaimAvailable, Channel: "simstreamer"},
+			{
+				At: 100 * time.Second, Type: EventPredictionCreated, Channel: "simstreamer", ID: "sim-prediction",
+				Title: "Will we win?", Outcomes: []string{"Yes", "No"}, OutcomePoints: []int{40_000, 10_000}, Window: 60,
+			},
+			{At: 160 * time.Second, Type: EventPredictionLocked, Channel: "simstreamer", ID: "sim-prediction"},
+			{At: 180 * time.Second, Type: EventPredictionResolve, Channel: "simstreamer", ID: "sim-prediction", Winner: "No"},
+			{At: 200 * time.Second, Type: EventRaid, Channel: "simraider", Target: "simstreamer"},
+			{At: 240 * time.Second, Type: EventStreamDown, Channel: "simraider"},
+		},
+	}
+	if err := script.validate(); err != nil {
+		panic(err)
+	}
+	return script
+}
@@ -0,0 +1,291 @@
+// Package simulator is a local stand-in for the parts of Twitch the miner talks to.
+// It serves GraphQL persisted operations, PubSub, EventSub, IRC, usher, spade and the device login flow
+// and plays a scripted timeline of events, so the miner can be run end-to-end without a real account.
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"golang.org/x/net/websocket"
+)
+
+type Server struct {
+	script *Script
+
+	httpListener net.Listener
+	ircListener  net.Listener
+	httpServer   *http.Server
+
+	lock        sync.Mutex
+	users       []*user
+	channels    []*channel
+	predictions map[string]*prediction
+	pubsub      []*pubsubConn
+	irc         []*ircConn
+	calls       map[string]int
+
+	eventsub              []*eventSubConn
+	eventSubSubscriptions []*eventSubSubscription
+	eventSubSessions      int
+	eventSubIDs           int
+}
+
+type user struct {
+	ScriptUser
+	// follows starts as the Follows of the script and is changed by follow and unfollow events
+	follows map[string]bool
+	// revoked tokens are rejected everywhere
+	revoked bool
+}
+
+type channel struct {
+	ScriptChannel
+	points  map[*user]int
+	watched map[*user]int
+}
+
+func NewServer(script *Script) *Server {
+	s := &Server{
+		script:      script,
+		predictions: map[string]*prediction{},
+		calls:       map[string]int{},
+	}
+	for _, u := range script.Users {
+		follows := map[string]bool{}
+		for _, c := range script.Channels {
+			follows[c.Name] = len(u.Follows) == 0 || slices.Contains(u.Follows, c.Name)
+		}
+		s.users = append(s.users, &user{ScriptUser: u, follows: follows})
+	}
+	for _, c := range script.Channels {
+		ch := &channel{c, map[*user]int{}, map[*user]int{}}
+		for _, u := range s.users {
+			ch.points[u] = c.Points
+		}
+		s.channels = append(s.channels, ch)
+	}
+	return s
+}
+
+// Start listens on the given addresses. Use port 0 to pick a free port
+func (s *Server) Start(httpAddr string, ircAddr string) error {
+	httpListener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+	ircListener, err := net.Listen("tcp", ircAddr)
+	if err != nil {
+		_ = httpListener.Close()
+		return err
+	}
+	s.httpListener = httpListener
+	s.ircListener = ircListener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /gql", s.handleGraphQL)
+	mux.HandleFunc("GET /api/channel/hls/{file}", s.handleUsher)
+	mux.HandleFunc("GET /hls/{channel}/{file}", s.handlePlaylist)
+	mux.HandleFunc("POST /spade", s.handleSpade)
+	mux.HandleFunc("GET /config/{file}", s.handleSettings)
+	mux.HandleFunc("POST /oauth2/device", s.handleDevice)
+	mux.HandleFunc("POST /oauth2/token", s.handleToken)
+	mux.HandleFunc("GET /oauth2/validate", s.handleValidate)
+	mux.Handle("/pubsub", websocket.Handler(s.handlePubSub))
+	mux.Handle("/eventsub", websocket.Handler(s.handleEventSub))
+	mux.HandleFunc("POST /helix/eventsub/subscriptions", s.handleHelixCreateSubscription)
+	mux.HandleFunc("DELETE /helix/eventsub/subscriptions", s.handleHelixDeleteSubscription)
+	mux.HandleFunc("GET /{$}", s.handleWebsite)
+
+	s.httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := s.httpServer.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log("HTTP server stopped:", err)
+		}
+	}()
+	go s.serveIRC()
+
+	s.log("Listening on", httpListener.Addr(), "(http, pubsub, eventsub) and", ircListener.Addr(), "(irc)")
+	return nil
+}
+
+func (s *Server) Close() error {
+	s.lock.Lock()
+	for _, conn := range s.pubsub {
+		_ = conn.conn.Close()
+	}
+	for _, conn := range s.eventsub {
+		_ = conn.conn.Close()
+	}
+	for _, conn := range s.irc {
+		_ = conn.conn.Close()
+	}
+	s.lock.Unlock()
+
+	errs := []error{}
+	if s.ircListener != nil {
+		errs = append(errs, s.ircListener.Close())
+	}
+	if s.httpServer != nil {
+		errs = append(errs, s.httpServer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Endpoints returns the endpoints to configure the miner with
+func (s *Server) Endpoints() miner.Endpoints {
+	base := "http://" + s.httpListener.Addr().String()
+	return miner.Endpoints{
+		Website:  base,
+		GraphQL:  base + "/gql",
+		Usher:    base,
+		ID:       base,
+		PubSub:   "ws://" + s.httpListener.Addr().String() + "/pubsub",
+		EventSub: "ws://" + s.httpListener.Addr().String() + "/eventsub",
+		Helix:    base + "/helix",
+		IRC:      s.ircListener.Addr().String(),
+		IRCTLS:   false,
+	}
+}
+
+// Script returns the script the server was created with
+func (s *Server) Script() *Script {
+	return s.script
+}
+
+// Calls returns how often each GraphQL operation (and spade) has been called
+func (s *Server) Calls() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	calls := make(map[string]int, len(s.calls))
+	for k, v := range s.calls {
+		calls[k] = v
+	}
+	return calls
+}
+
+// Play runs the timeline of the script until all events were emitted or the context is cancelled.
+// Live channels receive a viewcount every 30 seconds, like on Twitch.
+func (s *Server) Play(ctx context.Context) {
+	events := slices.Clone(s.script.Events)
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return int(a.At - b.At)
+	})
+
+	start := time.Now()
+	viewcount := time.NewTicker(30 * time.Second)
+	defer viewcount.Stop()
+
+	for len(events) > 0 {
+		timer := time.NewTimer(time.Until(start.Add(events[0].At)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-viewcount.C:
+			timer.Stop()
+			s.sendViewcounts()
+		case <-timer.C:
+			if err := s.Emit(events[0]); err != nil {
+				s.log("Failed to emit event", events[0].Type, err)
+			}
+			events = events[1:]
+		}
+	}
+
+	s.log("Timeline finished")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-viewcount.C:
+			s.sendViewcounts()
+		}
+	}
+}
+
+func (s *Server) sendViewcounts() {
+	s.lock.Lock()
+	live := []*channel{}
+	for _, ch := range s.channels {
+		if ch.Live {
+			live = append(live, ch)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, ch := range live {
+		s.publish("video-playback-by-id."+ch.ID, map[string]any{
+			"type":        "viewcount",
+			"server_time": serverTime(),
+			"viewers":     ch.Viewers,
+		})
+	}
+}
+
+func (s *Server) userByToken(token string) *user {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, u := range s.users {
+		if u.Token == token && !u.revoked {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) userByName(name string) *user {
+	for _, u := range s.users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) channelByName(name string) *channel {
+	for _, ch := range s.channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (s *Server) channelByID(id string) *channel {
+	for _, ch := range s.channels {
+		if ch.ID == id {
+			return ch
+		}
+	}
+	return nil
+}
+
+// usersFor returns the users an event targets, all users if name is empty
+func (s *Server) usersFor(name string) []*user {
+	if name == "" {
+		return slices.Clone(s.users)
+	}
+	if u := s.userByName(name); u != nil {
+		return []*user{u}
+	}
+	return nil
+}
+
+func (s *Server) log(content ...any) {
+	content = append([]any{"[sim]"}, content...)
+	fmt.Println(content...)
+}
+
+func serverTime() float64 {
+	return float64(time.Now().UnixMilli()) / 1000
+}
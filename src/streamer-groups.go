@@ -0,0 +1,84 @@
+package miner
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// StreamerGroup shares a priority and options between streamers.
+// A streamer can be in several groups, later groups override earlier ones and the options of the streamer override all groups.
+type StreamerGroup struct {
+	Name      string
+	Streamers []string
+	// Priority is used for the streamers that have no priority of their own
+	Priority *int
+	OptionOverrides
+	// Schedule limits when the group applies, without windows it always applies
+	Schedule []TimeWindow
+}
+
+// Active returns whether the group applies at the time
+func (g StreamerGroup) Active(t time.Time) bool {
+	return len(g.Schedule) == 0 || slices.ContainsFunc(g.Schedule, func(window TimeWindow) bool { return window.Contains(t) })
+}
+
+// TimeWindow is a daily time span in local time
+type TimeWindow struct {
+	// Days the window starts on, every day if empty
+	Days []time.Weekday
+	// From and To are offsets from midnight. Windows with To before From end on the next day, windows with From equal to To last the whole day
+	From time.Duration
+	To   time.Duration
+}
+
+// Contains returns whether the time is inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	startsOn := func(day time.Weekday) bool {
+		return len(w.Days) == 0 || slices.Contains(w.Days, day)
+	}
+
+	switch {
+	case w.From == w.To:
+		return startsOn(t.Weekday())
+	case w.From < w.To:
+		return startsOn(t.Weekday()) && offset >= w.From && offset < w.To
+	default:
+		yesterday := (t.Weekday() + 6) % 7
+		return (startsOn(t.Weekday()) && offset >= w.From) || (startsOn(yesterday) && offset < w.To)
+	}
+}
+
+func (w TimeWindow) validate() error {
+	if w.From < 0 || w.From > 24*time.Hour || w.To < 0 || w.To > 24*time.Hour {
+		return fmt.Errorf("time window %s-%s is outside of a day", w.From, w.To)
+	}
+	return nil
+}
+
+// groupsOf returns the groups the streamer is in, in the order they apply
+func (o Options) groupsOf(streamer string) []StreamerGroup {
+	groups := []StreamerGroup{}
+	for _, group := range o.StreamerGroups {
+		if slices.Contains(group.Streamers, streamer) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Priority returns the priority of the streamer, which is the one of streamers.streamers or of the last active group
+func (o Options) Priority(streamer string) int {
+	if priority, ok := o.StreamerPriority[streamer]; ok {
+		return priority
+	}
+	now := clockOrReal(o.Clock).Now()
+	priority := 0
+	for _, group := range o.groupsOf(streamer) {
+		if group.Priority != nil && group.Active(now) {
+			priority = *group.Priority
+		}
+	}
+	return priority
+}
@@ -1,26 +1,213 @@
 package miner
 
-import "time"
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
 
+// Streamer is shared by all users and updated from transport goroutines while the scheduler mines,
+// so everything that changes after creation is only accessible through the methods below.
 type Streamer struct {
 	Username string
 	ID       string
 
-	Points        map[*User]int
-	GotPointsOnce map[*User]bool
-	BroadcastID   string
+	points        map[*User]int
+	gotPointsOnce map[*User]bool
+	broadcastID   string
 
-	Viewers      int
-	LastLivePing time.Time
-	WasLive      bool
+	viewers      int
+	lastLivePing time.Time
+	wasLive      bool
 
-	LiveTopics []*WebsocketTopic
+	// topics are listened to all the time, liveTopics only while the streamer is live
+	topics     []*WebsocketTopic
+	liveTopics []*WebsocketTopic
+	removed    bool
+
+	clock Clock
+	lock  sync.RWMutex
 }
 
 func (s *Streamer) IsLive() bool {
-	return time.Since(s.LastLivePing) < 5*time.Minute
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.isLive()
+}
+
+func (s *Streamer) isLive() bool {
+	return clockOrReal(s.clock).Since(s.lastLivePing) < 5*time.Minute
+}
+
+func (s *Streamer) Points(user *User) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.points[user]
+}
+
+// AllPoints returns a copy of the points of every user
+func (s *Streamer) AllPoints() map[*User]int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return maps.Clone(s.points)
+}
+
+// SetPoints updates the balance of the user, watched marks that the user got points for watching this stream
+func (s *Streamer) SetPoints(user *User, points int, watched bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.points[user] = points
+	if watched {
+		s.gotPointsOnce[user] = true
+	}
+}
+
+// forget drops the balance of a user that no longer mines the streamer
+func (s *Streamer) forget(user *User) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.points, user)
+	delete(s.gotPointsOnce, user)
+}
+
+// GotPointsOnce returns whether the user got points for watching the current stream
+func (s *Streamer) GotPointsOnce(user *User) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.gotPointsOnce[user]
+}
+
+func (s *Streamer) BroadcastID() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.broadcastID
+}
+
+func (s *Streamer) SetBroadcastID(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.broadcastID = id
+}
+
+// Viewers returns the last known viewer count, or 0 if the streamer is offline
+func (s *Streamer) Viewers() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if !s.isLive() {
+		return 0
+	}
+	return s.viewers
 }
 
-func (s Streamer) ChannelName() string {
+// StreamUp marks the streamer as live, the streak bonus can be claimed again
+func (s *Streamer) StreamUp() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastLivePing = clockOrReal(s.clock).Now()
+	s.viewers = 0
+	clear(s.gotPointsOnce)
+}
+
+func (s *Streamer) StreamDown() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastLivePing = time.Time{}
+	s.broadcastID = ""
+	s.viewers = 0
+}
+
+// UpdateViewers keeps the streamer live
+func (s *Streamer) UpdateViewers(viewers int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastLivePing = clockOrReal(s.clock).Now()
+	s.viewers = viewers
+}
+
+// UpdateLiveState returns whether the streamer is live and whether that changed since the last call.
+// Removed streamers never change, so their topics are not listened to again.
+func (s *Streamer) UpdateLiveState() (live bool, changed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.removed {
+		return s.wasLive, false
+	}
+	live = s.isLive()
+	changed = live != s.wasLive
+	s.wasLive = live
+	return live, changed
+}
+
+// LiveTopics returns the topics only listened to while the streamer is live
+func (s *Streamer) LiveTopics() []*WebsocketTopic {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return slices.Clone(s.liveTopics)
+}
+
+// setTopics sets the topics of the streamer, it returns false if they were already set
+func (s *Streamer) setTopics(topics []*WebsocketTopic, liveTopics []*WebsocketTopic) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.topics != nil || s.removed {
+		return false
+	}
+	s.topics = topics
+	s.liveTopics = liveTopics
+	return true
+}
+
+// replaceTopics changes the topics of the streamer and returns the topics to listen to and unlisten right now.
+// Live topics only change what is listened to while the streamer is live. Streamers without topics yet are left alone.
+func (s *Streamer) replaceTopics(topics []*WebsocketTopic, liveTopics []*WebsocketTopic) (listen []*WebsocketTopic, unlisten []*WebsocketTopic) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.topics == nil || s.removed {
+		return nil, nil
+	}
+	s.topics, listen, unlisten = mergeTopics(s.topics, topics)
+	var addedLive, removedLive []*WebsocketTopic
+	s.liveTopics, addedLive, removedLive = mergeTopics(s.liveTopics, liveTopics)
+	if s.wasLive {
+		listen = append(listen, addedLive...)
+		unlisten = append(unlisten, removedLive...)
+	}
+	return listen, unlisten
+}
+
+// remove marks the streamer as removed and returns all topics currently listened to
+func (s *Streamer) remove() []*WebsocketTopic {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removed = true
+	topics := slices.Clone(s.topics)
+	if s.wasLive {
+		topics = append(topics, s.liveTopics...)
+	}
+	return topics
+}
+
+func (s *Streamer) ChannelName() string {
 	return "#" + s.Username
 }
+
+func NewStreamer(username string, id string, clock Clock) *Streamer {
+	return &Streamer{
+		Username:      username,
+		ID:            id,
+		points:        map[*User]int{},
+		gotPointsOnce: map[*User]bool{},
+		clock:         clock,
+	}
+}
@@ -0,0 +1,154 @@
+package miner
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidToken is returned when Twitch does not accept the token, because it expired, was revoked or is mistyped
+var ErrInvalidToken = errors.New("token is invalid, expired or was revoked")
+
+// TokenInfo describes a token, see https://dev.twitch.tv/docs/authentication/validate-tokens
+type TokenInfo struct {
+	ClientID string   `json:"client_id"`
+	Login    string   `json:"login"`
+	UserID   string   `json:"user_id"`
+	Scopes   []string `json:"scopes"`
+	// ExpiresIn is in seconds, 0 for tokens that do not expire
+	ExpiresIn int `json:"expires_in"`
+}
+
+// ValidateToken asks Twitch who the token belongs to
+func ValidateToken(endpoints Endpoints, token string) (TokenInfo, error) {
+	return validateToken(http.DefaultClient, endpoints, token)
+}
+
+// ValidateToken asks Twitch who the token of the user belongs to
+func (u *User) ValidateToken() (TokenInfo, error) {
+	return validateToken(u.GraphQL.Client, u.Miner.GetOptions().Endpoints, u.AuthToken)
+}
+
+func validateToken(client *http.Client, endpoints Endpoints, token string) (TokenInfo, error) {
+	var info TokenInfo
+	request, err := http.NewRequest("GET", endpoints.ID+"/oauth2/validate", nil)
+	if err != nil {
+		return info, err
+	}
+	request.Header.Set("Authorization", "OAuth "+token)
+	request.Header.Set("User-Agent", userAgent)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return info, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(response.Body).Decode(&info)
+		return info, err
+	case http.StatusUnauthorized:
+		return info, ErrInvalidToken
+	}
+	return info, fmt.Errorf("failed to validate token: %s", response.Status)
+}
+
+// requiredScopes returns the scopes the token of the user needs for the enabled features
+func (o Options) requiredScopes(username string) []string {
+	scopes := []string{}
+	options := o.ForUser(username)
+	if options.AnyStreamer(func(o Options) bool { return o.MineWatchtime }) {
+		scopes = append(scopes, "chat:read")
+	}
+	// the follows are read through GraphQL, which needs no scope
+	return scopes
+}
+
+// checkToken returns why the token can not be used by the user
+func (o Options) checkToken(username string, info TokenInfo) error {
+	if username != "" && !strings.EqualFold(username, info.Login) {
+		return fmt.Errorf("token belongs to %s, not %s", info.Login, username)
+	}
+	// first party tokens, like the auth-token cookie of the website, list no scopes but are allowed everything
+	if len(info.Scopes) == 0 {
+		return nil
+	}
+	missing := []string{}
+	for _, scope := range o.requiredScopes(cmp.Or(username, info.Login)) {
+		if !slices.Contains(info.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("token is missing the scopes %s, log in again to get a new token", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RevalidateTokens validates the tokens of all users, which Twitch expects hourly.
+// Users whose token stopped working are paused.
+func (miner *Miner) RevalidateTokens() error {
+	errs := []error{}
+	for _, user := range miner.GetUsers() {
+		if user.AuthError() != nil {
+			continue
+		}
+		_, err := user.ValidateToken()
+		if errors.Is(err, ErrInvalidToken) {
+			user.authFailed("validate", err)
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", user.Username, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AuthError returns why Twitch rejected the token of the user, nil while it works.
+// Users with an auth error are paused: they do not chat, mine points, claim, join raids or bet.
+func (u *User) AuthError() error {
+	u.Miner.Lock.Lock()
+	defer u.Miner.Lock.Unlock()
+	return u.authError()
+}
+
+// authError is AuthError for callers holding the lock
+func (u *User) authError() error {
+	if u.Miner.Users[u.Username] != u {
+		return nil
+	}
+	return u.Miner.authErrors[u.Username]
+}
+
+// authFailed pauses the user because Twitch rejected its token on source. Chat is disconnected and the topics of the user are unlistened.
+// Only the first failure is published, everything else fails the same way until the user is added again with a new token.
+func (u *User) authFailed(source string, err error) {
+	miner := u.Miner
+	miner.Lock.Lock()
+	if miner.Users[u.Username] != u || miner.authErrors[u.Username] != nil {
+		miner.Lock.Unlock()
+		return
+	}
+	err = fmt.Errorf("%s: %w", source, err)
+	miner.authErrors[u.Username] = err
+	chat := u.Chat
+	u.Chat = nil
+	topics := u.topics
+	u.topics = nil
+	miner.Lock.Unlock()
+
+	fmt.Println("Pausing user", u.Username+", the token was rejected by", err)
+	if chat != nil {
+		chat.Stop()
+	}
+	if len(topics) > 0 {
+		if err := miner.Transport.Unlisten(topics...); err != nil {
+			fmt.Println("Error unlistening topics of", u.Username, err)
+		}
+	}
+	miner.Events.Publish(AuthFailed{User: u, Err: err})
+}
@@ -0,0 +1,32 @@
+package miner_test
+
+import (
+	"testing"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+func TestAddUserScopes(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users: []simulator.ScriptUser{
+			// follows are read through GraphQL, they need no scope
+			{Name: "follower", Scopes: []string{"chat:read"}},
+			{Name: "lurker", Scopes: []string{"user_read"}},
+		},
+	})
+	instance, err := miner.New(miner.WithEndpoints(server.Endpoints()), miner.WithPersistentFile(""), func(o *miner.Options) {
+		o.FollowStreamers = true
+		o.MineWatchtime = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := instance.AddUser(miner.NewUser("follower", "sim-follower")); err != nil {
+		t.Errorf("token without user_follows_edit was rejected: %v", err)
+	}
+	if err := instance.AddUser(miner.NewUser("lurker", "sim-lurker")); err == nil {
+		t.Error("token without chat:read was accepted although watchtime is mined")
+	}
+}
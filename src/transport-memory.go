@@ -0,0 +1,99 @@
+package miner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MemoryTransport is a Transport without any network, for tests.
+// Every topic is accepted right away and Publish delivers messages like Twitch would.
+type MemoryTransport struct {
+	topics   []*WebsocketTopic
+	messages *messageQueue
+
+	lock sync.Mutex
+}
+
+// Listen listens to all topics, or to none of them if one is already listened to
+func (t *MemoryTransport) Listen(topics ...*WebsocketTopic) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i, topic := range topics {
+		if slices.Contains(t.topics, topic) || slices.Contains(topics[:i], topic) {
+			return fmt.Errorf("already listening to %s", topic.GetTopicName())
+		}
+	}
+	for _, topic := range topics {
+		topic.State = TopicStateListening
+		t.topics = append(t.topics, topic)
+	}
+	return nil
+}
+
+// Unlisten stops listening to all topics, or to none of them if one is not listened to
+func (t *MemoryTransport) Unlisten(topics ...*WebsocketTopic) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for i, topic := range topics {
+		if !slices.Contains(t.topics, topic) || slices.Contains(topics[:i], topic) {
+			return fmt.Errorf("topic %s not found", topic.GetTopicName())
+		}
+	}
+	t.topics = slices.DeleteFunc(t.topics, func(topic *WebsocketTopic) bool {
+		return slices.Contains(topics, topic)
+	})
+	return nil
+}
+
+// Listening returns the names of all topics currently listened to
+func (t *MemoryTransport) Listening() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	names := make([]string, 0, len(t.topics))
+	for _, topic := range t.topics {
+		names = append(names, topic.GetTopicName())
+	}
+	return names
+}
+
+// Publish delivers the message if its topic is listened to and returns whether it was delivered
+func (t *MemoryTransport) Publish(message WebsocketMessage) bool {
+	if !slices.Contains(t.Listening(), strings.Join(message.Topics, ".")) {
+		return false
+	}
+	t.messages.send(message)
+	return true
+}
+
+func (t *MemoryTransport) Messages() <-chan WebsocketMessage {
+	return t.messages.ch
+}
+
+func (t *MemoryTransport) Topics() []TopicStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	statuses := make([]TopicStatus, 0, len(t.topics))
+	for _, topic := range t.topics {
+		status := TopicStatus{Name: topic.GetTopicName(), State: topic.State, Connection: -1}
+		if topic.User != nil {
+			status.Username = topic.User.Username
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (t *MemoryTransport) Close() error {
+	t.messages.close()
+	return nil
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{messages: newMessageQueue()}
+}
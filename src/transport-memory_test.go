@@ -0,0 +1,98 @@
+package miner_test
+
+import (
+	"testing"
+	"time"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+func TestMemoryTransportListenDuplicate(t *testing.T) {
+	transport := miner.NewMemoryTransport()
+	streamer := miner.NewStreamer("streamer", "2000", nil)
+	playback := &miner.WebsocketTopic{Topic: "video-playback-by-id", Streamer: streamer}
+	raid := &miner.WebsocketTopic{Topic: "raid", Streamer: streamer}
+
+	if err := transport.Listen(playback); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Listen(raid, playback); err == nil {
+		t.Error("listening to a topic twice should fail")
+	}
+	if err := transport.Listen(raid, raid); err == nil {
+		t.Error("listening to the same topic twice in one call should fail")
+	}
+	assertListening(t, transport, "video-playback-by-id.2000")
+
+	if err := transport.Unlisten(playback, raid); err == nil {
+		t.Error("unlistening a topic that is not listened to should fail")
+	}
+	assertListening(t, transport, "video-playback-by-id.2000")
+}
+
+func TestSubscribeToTopics(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}, {Name: "other"}},
+	})
+	instance, transport := newTestMiner(t, server)
+	if err := instance.BulkAddStreamers(instance.GetDefaultUser(), []string{"streamer", "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	instance.SubscribeToTopics()
+	assertListening(t, transport,
+		"community-points-user-v1.1000", "predictions-user-v1.1000",
+		"video-playback-by-id.2000", "video-playback-by-id.2001",
+	)
+
+	// everything was listened to already
+	instance.SubscribeToTopics()
+	if got := len(transport.Listening()); got != 4 {
+		t.Errorf("listening to %d topics after subscribing again, want 4", got)
+	}
+}
+
+func TestUpdateStreamerTopicSubscriptions(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Users:    []simulator.ScriptUser{{Name: "user"}},
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}},
+	})
+	clock := miner.NewFakeClock(start)
+	instance, transport := newTestMiner(t, server, miner.WithClock(clock))
+	streamer, err := instance.AddStreamer("streamer", instance.GetDefaultUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.SubscribeToTopics()
+
+	liveTopics := []string{
+		"community-points-user-v1.1000", "predictions-user-v1.1000", "video-playback-by-id.2000",
+		"raid.2000", "community-moments-channel-v1.2000", "predictions-channel-v1.2000",
+	}
+	offlineTopics := liveTopics[:3]
+
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, offlineTopics...)
+
+	streamer.StreamUp()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, liveTopics...)
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, liveTopics...)
+
+	streamer.StreamDown()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, offlineTopics...)
+
+	// without viewcount updates the stream counts as offline after 5 minutes
+	streamer.StreamUp()
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	clock.Advance(4 * time.Minute)
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, liveTopics...)
+	clock.Advance(time.Minute)
+	_ = instance.UpdateStreamerTopicSubscriptions()
+	assertListening(t, transport, offlineTopics...)
+}
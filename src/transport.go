@@ -0,0 +1,135 @@
+package miner
+
+import (
+	"errors"
+	"sync"
+)
+
+// Transport is a realtime source of the events the miner reacts to, like PubSub or EventSub.
+// Messages are delivered in the PubSub format consumed by Miner.OnMessage.
+type Transport interface {
+	Listen(topics ...*WebsocketTopic) error
+	Unlisten(topics ...*WebsocketTopic) error
+	// Messages is closed once the transport is closed
+	Messages() <-chan WebsocketMessage
+	// Topics returns a snapshot of all topics and their state
+	Topics() []TopicStatus
+	Close() error
+}
+
+// TopicFilter is implemented by transports that only handle some topics
+type TopicFilter interface {
+	Supports(topic *WebsocketTopic) bool
+}
+
+// RoutedTransport sends every topic to the first transport supporting it and merges their messages.
+// Transports not implementing TopicFilter support every topic, so the fallback goes last.
+type RoutedTransport struct {
+	transports []Transport
+	messages   *messageQueue
+}
+
+func (t *RoutedTransport) Listen(topics ...*WebsocketTopic) error {
+	errs := []error{}
+	for transport, routed := range t.route(topics) {
+		if err := transport.Listen(routed...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *RoutedTransport) Unlisten(topics ...*WebsocketTopic) error {
+	errs := []error{}
+	for transport, routed := range t.route(topics) {
+		if err := transport.Unlisten(routed...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *RoutedTransport) route(topics []*WebsocketTopic) map[Transport][]*WebsocketTopic {
+	routes := map[Transport][]*WebsocketTopic{}
+	for _, topic := range topics {
+		for _, transport := range t.transports {
+			if filter, ok := transport.(TopicFilter); ok && !filter.Supports(topic) {
+				continue
+			}
+			routes[transport] = append(routes[transport], topic)
+			break
+		}
+	}
+	return routes
+}
+
+func (t *RoutedTransport) Messages() <-chan WebsocketMessage {
+	return t.messages.ch
+}
+
+func (t *RoutedTransport) Topics() []TopicStatus {
+	topics := []TopicStatus{}
+	for _, transport := range t.transports {
+		topics = append(topics, transport.Topics()...)
+	}
+	return topics
+}
+
+func (t *RoutedTransport) Close() error {
+	errs := []error{}
+	for _, transport := range t.transports {
+		errs = append(errs, transport.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func NewRoutedTransport(transports ...Transport) *RoutedTransport {
+	t := &RoutedTransport{transports, newMessageQueue()}
+
+	wg := sync.WaitGroup{}
+	for _, transport := range transports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for message := range transport.Messages() {
+				t.messages.send(message)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		t.messages.close()
+	}()
+	return t
+}
+
+// messageQueue backs the Messages channel of a transport.
+// Unlike a plain channel, sending after it was closed is fine (the message is dropped).
+type messageQueue struct {
+	ch     chan WebsocketMessage
+	closed bool
+	lock   sync.RWMutex
+}
+
+func (q *messageQueue) send(message WebsocketMessage) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	if !q.closed {
+		q.ch <- message
+	}
+}
+
+func (q *messageQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+func newMessageQueue() *messageQueue {
+	return &messageQueue{ch: make(chan WebsocketMessage, 100)}
+}
@@ -1,21 +1,52 @@
 package miner
 
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
 type User struct {
 	Username  string
 	ID        string
 	AuthToken string
-	Chat      *Chat
-	GraphQL   *GraphQL
+	// Chat is nil until ConnectToChat was called, use GetChat once the miner is running
+	Chat    *Chat
+	GraphQL *GraphQL
 
 	Streamers map[string]*Streamer
 	Miner     *Miner
+
+	// topics are set once the user is listened to
+	topics []*WebsocketTopic
+}
+
+// GetStreamers returns a snapshot of the streamers the user mines
+func (u *User) GetStreamers() []*Streamer {
+	u.Miner.Lock.Lock()
+	defer u.Miner.Lock.Unlock()
+	return slices.Collect(maps.Values(u.Streamers))
 }
 
+func (u *User) GetChat() *Chat {
+	u.Miner.Lock.Lock()
+	defer u.Miner.Lock.Unlock()
+	return u.Chat
+}
+
+// ConnectToChat connects the user to chat, unless it already is or the user is paused
 func (u *User) ConnectToChat() {
-	if u.Chat == nil {
-		u.Chat = NewChat(u)
+	u.Miner.Lock.Lock()
+	if u.Chat != nil || u.authError() != nil {
+		u.Miner.Lock.Unlock()
+		return
 	}
-	go u.Chat.RunForever()
+	u.Chat = NewChat(u)
+	chat := u.Chat
+	u.Miner.Lock.Unlock()
+
+	fmt.Println("Connecting to chat for user", u.Username)
+	go chat.RunForever()
 }
 
 func NewUser(username string, authToken string) *User {
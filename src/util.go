@@ -1,6 +1,9 @@
 package miner
 
-import "math/rand"
+import (
+	"math/rand"
+	"time"
+)
 
 const (
 	hexAlphabet   = "0123456789abcdef"
@@ -19,3 +22,12 @@ func createRandomString(length int, alphabetOptional ...string) string {
 	}
 	return string(result)
 }
+
+// jitteredBackoff doubles the delay for every attempt, capped at maximum, and picks a random delay between half and the full value
+func jitteredBackoff(attempt int, base time.Duration, maximum time.Duration) time.Duration {
+	delay := maximum
+	if attempt < 32 {
+		delay = min(base<<max(attempt-1, 0), maximum)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -1,11 +1,15 @@
 package miner
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 type RawWebsocketMessage struct {
 	Type  string                   `json:"type"`
 	Data  *RawWebsocketMessageData `json:"data"`
 	Error *string                  `json:"error"`
+	Nonce string                   `json:"nonce,omitempty"`
 }
 type RawWebsocketMessageData struct {
 	Topic   string `json:"topic"`
@@ -21,3 +25,13 @@ type WebsocketMessage struct {
 	Type   string
 	Data   json.RawMessage
 }
+
+// Parse converts a raw MESSAGE into the shape consumed by Miner.OnMessage
+func (raw RawWebsocketMessage) Parse() (WebsocketMessage, error) {
+	var content WebsocketMessageContent
+	if err := json.Unmarshal([]byte(raw.Data.Message), &content); err != nil {
+		return WebsocketMessage{}, err
+	}
+	topics := strings.Split(raw.Data.Topic, ".")
+	return WebsocketMessage{topics, content.Type, json.RawMessage(raw.Data.Message)}, nil
+}
@@ -1,90 +1,448 @@
 package miner
 
 import (
+	"errors"
 	"fmt"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	websocketUrl           = "wss://pubsub-edge.twitch.tv"
 	maxTopicsPerConnection = 50
+	// how often a topic is retried after a transient error like ERR_SERVER
+	maxListenAttempts = 3
+	listenRetryDelay  = 10 * time.Second
+	// reconnect backoff after failed connections, doubled for every failure
+	minConnectBackoff = time.Second
+	maxConnectBackoff = 5 * time.Minute
+	// connections that die sooner than this count as failed
+	minHealthyConnectionTime = time.Minute
 )
 
 type WebsocketPool struct {
+	url           string
+	clock         Clock
 	connections   []*WebsocketConnection
 	connectionIDs int
 	topics        []*WebsocketTopic
-	OnMessage     *func(WebsocketMessage)
+	messages      *messageQueue
+	Recorder      *Recorder
+	Events        *EventBus
+	closed        bool
+
+	// MaxConnectionsPerMinute caps how many new connections are opened per minute, 0 for unlimited
+	MaxConnectionsPerMinute int
+	connectTimes            []time.Time
+	connectFailures         int
+	nextConnect             time.Time
+	revalidateTimer         Timer
+	revalidateAt            time.Time
+
+	lock sync.Mutex
+}
+
+func (pool *WebsocketPool) Listen(topics ...*WebsocketTopic) error {
+	return pool.ListenTopics(topics...)
+}
+
+func (pool *WebsocketPool) Unlisten(topics ...*WebsocketTopic) error {
+	return pool.UnlistenTopics(topics...)
+}
+
+func (pool *WebsocketPool) Messages() <-chan WebsocketMessage {
+	return pool.messages.ch
+}
+
+// Close disconnects all connections, the pool can not be used afterwards
+func (pool *WebsocketPool) Close() error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.closed = true
+	if pool.revalidateTimer != nil {
+		pool.revalidateTimer.Stop()
+		pool.revalidateTimer = nil
+	}
+	for _, conn := range pool.connections {
+		conn.Close()
+	}
+	pool.connections = nil
+	pool.messages.close()
+	return nil
 }
 
 func (pool *WebsocketPool) ListenTopic(topic *WebsocketTopic) error {
-	pool.topics = append(pool.topics, topic)
-	return pool.SubmitTopic(topic)
+	return pool.ListenTopics(topic)
+}
+
+// ListenTopics listens to all topics, sending one LISTEN per user and connection
+func (pool *WebsocketPool) ListenTopics(topics ...*WebsocketTopic) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if pool.closed {
+		return fmt.Errorf("pool is closed")
+	}
+
+	pool.topics = append(pool.topics, topics...)
+	return pool.submitTopics(topics...)
 }
 
 func (pool *WebsocketPool) UnlistenTopic(topic *WebsocketTopic) error {
-	index := slices.Index(pool.topics, topic)
-	if index != -1 {
+	return pool.UnlistenTopics(topic)
+}
+
+func (pool *WebsocketPool) UnlistenTopics(topics ...*WebsocketTopic) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	errs := []error{}
+	connections := map[*WebsocketConnection][]*WebsocketTopic{}
+	for _, topic := range topics {
+		index := slices.Index(pool.topics, topic)
+		if index == -1 {
+			errs = append(errs, fmt.Errorf("topic %s not found in pool", topic.GetTopicName()))
+			continue
+		}
 		pool.topics = slices.Delete(pool.topics, index, index+1)
-	} else {
-		return fmt.Errorf("topic not found in pool")
+		if topic.AssignedTo != nil {
+			connections[topic.AssignedTo] = append(connections[topic.AssignedTo], topic)
+		}
 	}
-	return nil
+
+	for conn, connTopics := range connections {
+		if err := conn.UnlistenTopics(connTopics...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	pool.rebalance()
+	return errors.Join(errs...)
 }
 
-func (pool *WebsocketPool) SubmitTopic(topic *WebsocketTopic) error {
+// submitTopics fills up the free slots of existing connections first and opens new connections for the rest.
+// Topics that could not be submitted stay unassigned and are picked up by the next revalidation.
+func (pool *WebsocketPool) submitTopics(topics ...*WebsocketTopic) error {
+	errs := []error{}
 	for _, conn := range pool.connections {
-		if len(conn.topics) < maxTopicsPerConnection {
-			return conn.ListenTopics(topic)
+		if len(topics) == 0 {
+			break
+		}
+		free := maxTopicsPerConnection - len(conn.topics)
+		if free <= 0 {
+			continue
+		}
+		batch := topics[:min(free, len(topics))]
+		topics = topics[len(batch):]
+		if err := conn.ListenTopics(batch...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for len(topics) > 0 {
+		fmt.Println("No connections available, creating a new one")
+		conn, err := pool.openConnection()
+		if err != nil {
+			errs = append(errs, err)
+			break
 		}
+		batch := topics[:min(maxTopicsPerConnection, len(topics))]
+		topics = topics[len(batch):]
+		if err := conn.ListenTopics(batch...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// openConnection connects a new connection unless we're backing off or hit the connection limit
+func (pool *WebsocketPool) openConnection() (*WebsocketConnection, error) {
+	now := pool.clock.Now()
+	if now.Before(pool.nextConnect) {
+		pool.scheduleRevalidate(pool.nextConnect)
+		return nil, fmt.Errorf("backing off, not connecting until %s", pool.nextConnect.Format(time.TimeOnly))
 	}
-	fmt.Println("No connections available, creating a new one")
-	// No connections available or at the limit, create a new one
+
+	pool.connectTimes = slices.DeleteFunc(pool.connectTimes, func(t time.Time) bool {
+		return now.Sub(t) >= time.Minute
+	})
+	if pool.MaxConnectionsPerMinute > 0 && len(pool.connectTimes) >= pool.MaxConnectionsPerMinute {
+		at := pool.connectTimes[0].Add(time.Minute)
+		pool.scheduleRevalidate(at)
+		return nil, fmt.Errorf("opened %d connections in the last minute, not connecting until %s", len(pool.connectTimes), at.Format(time.TimeOnly))
+	}
+	pool.connectTimes = append(pool.connectTimes, now)
+
 	conn := NewWebsocketConnection(pool)
+	if err := conn.Connect(); err != nil {
+		delay := pool.backoff()
+		return nil, fmt.Errorf("failed to connect, retrying in %s: %w", delay.Round(time.Second), err)
+	}
 	pool.connections = append(pool.connections, conn)
-	return conn.ListenTopics(topic)
+	return conn, nil
+}
+
+// backoff registers a failed connection and delays the next one
+func (pool *WebsocketPool) backoff() time.Duration {
+	pool.connectFailures++
+	delay := jitteredBackoff(pool.connectFailures, minConnectBackoff, maxConnectBackoff)
+	pool.nextConnect = pool.clock.Now().Add(delay)
+	pool.scheduleRevalidate(pool.nextConnect)
+	return delay
+}
+
+// scheduleRevalidate makes sure a revalidation runs at the given time at the latest
+func (pool *WebsocketPool) scheduleRevalidate(at time.Time) {
+	if pool.revalidateTimer != nil && !pool.revalidateAt.After(at) {
+		return
+	}
+	if pool.revalidateTimer != nil {
+		pool.revalidateTimer.Stop()
+	}
+	pool.revalidateAt = at
+	pool.revalidateTimer = pool.clock.AfterFunc(pool.clock.Until(at), func() {
+		pool.lock.Lock()
+		pool.revalidateTimer = nil
+		pool.lock.Unlock()
+
+		if err := pool.RevalidateTopics(); err != nil {
+			fmt.Println("Error revalidating topics", err)
+		}
+	})
+}
+
+// rebalance closes the least used connection while the other connections have enough free slots for its topics.
+// Without this, unlistened topics would leave lots of half-empty connections behind.
+func (pool *WebsocketPool) rebalance() {
+	for len(pool.connections) > 1 {
+		emptiest := pool.connections[0]
+		for _, conn := range pool.connections[1:] {
+			if len(conn.topics) < len(emptiest.topics) {
+				emptiest = conn
+			}
+		}
+
+		free := 0
+		for _, conn := range pool.connections {
+			if conn != emptiest {
+				free += maxTopicsPerConnection - len(conn.topics)
+			}
+		}
+		if free < len(emptiest.topics) {
+			return
+		}
+
+		fmt.Println("Rebalancing", len(emptiest.topics), "topics from connection", emptiest.ID)
+		index := slices.Index(pool.connections, emptiest)
+		pool.connections = slices.Delete(pool.connections, index, index+1)
+
+		// listen on the new connections first so we don't miss any messages in between
+		topics := slices.Clone(emptiest.topics)
+		for _, topic := range topics {
+			topic.AssignedTo = nil
+		}
+		if err := pool.submitTopics(topics...); err != nil {
+			fmt.Println("Error moving topics", err)
+		}
+		emptiest.Close()
+	}
 }
 
 func (pool *WebsocketPool) RevalidateTopics() error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	return pool.revalidateTopics()
+}
+
+func (pool *WebsocketPool) revalidateTopics() error {
+	if pool.closed {
+		return nil
+	}
 	// when a connection is closed, we just remove it from the pool
 	// and this will pick up the missing topics
 	missingTopics := []*WebsocketTopic{}
 	for _, topic := range pool.topics {
+		if topic.State == TopicStateFailed {
+			continue
+		}
 		if topic.AssignedTo == nil || !slices.Contains(pool.connections, topic.AssignedTo) {
 			missingTopics = append(missingTopics, topic)
 		}
 	}
 
-	// TODO: this may send up to 50 INDIVIDUAL listen messages to the WS
-	// we should probably batch them
-	errors := []error{}
-	for _, topic := range missingTopics {
-		if err := pool.SubmitTopic(topic); err != nil {
-			errors = append(errors, err)
-		}
+	if len(missingTopics) == 0 {
+		return nil
 	}
-	if len(errors) > 0 {
-		return fmt.Errorf("errors revalidating topics: %v", errors)
+	if err := pool.submitTopics(missingTopics...); err != nil {
+		return fmt.Errorf("errors revalidating topics: %w", err)
 	}
 	return nil
 }
 
+// OnResponse matches a RESPONSE to the LISTEN that caused it and updates the state of its topics.
+// Errors only affect the topics of that request, not the whole connection.
+func (pool *WebsocketPool) OnResponse(conn *WebsocketConnection, response RawWebsocketMessage) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	request, ok := conn.pending[response.Nonce]
+	if !ok {
+		conn.log("Received response for unknown nonce", response.Nonce)
+		return
+	}
+	delete(conn.pending, response.Nonce)
+
+	errorCode := ""
+	if response.Error != nil {
+		errorCode = *response.Error
+	}
+
+	if request.messageType != "LISTEN" {
+		if errorCode != "" {
+			conn.log("Received error for", request.messageType, errorCode)
+		}
+		return
+	}
+
+	names := make([]string, 0, len(request.topics))
+	for _, topic := range request.topics {
+		names = append(names, topic.GetTopicName())
+	}
+	username := "anonymous"
+	if request.user != nil {
+		username = request.user.Username
+	}
+
+	if errorCode == "" {
+		// the connection works, no more need to back off
+		pool.connectFailures = 0
+		conn.log("Listening to", names, "for", username, "after", pool.clock.Since(request.sentAt))
+		for _, topic := range request.topics {
+			topic.State = TopicStateListening
+			topic.Error = ""
+			topic.Attempts = 0
+		}
+		return
+	}
+
+	conn.log("Failed to listen to", names, "for", username+":", errorCode)
+	if errorCode == "ERR_BADAUTH" && request.user != nil {
+		// the pause unlistens the topics, which needs the lock of the pool
+		go request.user.authFailed("pubsub", errors.New(errorCode))
+	}
+
+	// the topics were never listened to, so no UNLISTEN needed
+	retry := []*WebsocketTopic{}
+	for _, topic := range request.topics {
+		if index := slices.Index(conn.topics, topic); index != -1 {
+			conn.topics = slices.Delete(conn.topics, index, index+1)
+		}
+		if topic.AssignedTo == conn {
+			topic.AssignedTo = nil
+		}
+		topic.Error = errorCode
+		topic.Attempts++
+
+		if isTransientListenError(errorCode) && topic.Attempts < maxListenAttempts {
+			topic.State = TopicStatePending
+			retry = append(retry, topic)
+		} else {
+			topic.State = TopicStateFailed
+			fmt.Println("Giving up on topic", topic.GetTopicName(), "for", username+":", errorCode)
+		}
+	}
+
+	if len(retry) > 0 {
+		pool.clock.AfterFunc(listenRetryDelay*time.Duration(retry[0].Attempts), func() {
+			pool.lock.Lock()
+			defer pool.lock.Unlock()
+
+			topics := slices.DeleteFunc(retry, func(topic *WebsocketTopic) bool {
+				return topic.AssignedTo != nil || topic.State != TopicStatePending || !slices.Contains(pool.topics, topic)
+			})
+			if err := pool.submitTopics(topics...); err != nil {
+				fmt.Println("Error retrying topics", err)
+			}
+		})
+	}
+}
+
+func isTransientListenError(errorCode string) bool {
+	// ERR_BADAUTH, ERR_BADTOPIC and ERR_BADMESSAGE won't go away by retrying
+	return !strings.HasPrefix(errorCode, "ERR_BAD")
+}
+
+// Topics returns the state of all topics in the pool
+func (pool *WebsocketPool) Topics() []TopicStatus {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	statuses := make([]TopicStatus, 0, len(pool.topics))
+	for _, topic := range pool.topics {
+		status := TopicStatus{
+			Name:       topic.GetTopicName(),
+			State:      topic.State,
+			Error:      topic.Error,
+			Connection: -1,
+		}
+		if topic.User != nil {
+			status.Username = topic.User.Username
+		}
+		if topic.AssignedTo != nil {
+			status.Connection = topic.AssignedTo.ID
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
 func (pool *WebsocketPool) OnDisconnect(conn *WebsocketConnection) error {
-	fmt.Println("Connection disconnected", conn)
+	pool.lock.Lock()
+
+	fmt.Println("Connection disconnected", conn.ID)
 	for i, c := range pool.connections {
 		if c == conn {
 			pool.connections = slices.Delete(pool.connections, i, i+1)
 			break
 		}
 	}
-	return pool.RevalidateTopics()
+	// a connection that dies right away would otherwise be reopened in a tight loop
+	conn.lock.Lock()
+	alive := pool.clock.Since(conn.connectedAt)
+	conn.lock.Unlock()
+	if alive < minHealthyConnectionTime {
+		delay := pool.backoff()
+		fmt.Println("Connection", conn.ID, "died after", alive.Round(time.Second), "backing off for", delay.Round(time.Second))
+	}
+	err := pool.revalidateTopics()
+	pool.lock.Unlock()
+
+	// published without the lock, subscribers may call back into the pool
+	if pool.Events != nil {
+		pool.Events.Publish(ConnectionLost{Kind: "pubsub", Err: fmt.Errorf("connection %d disconnected after %s", conn.ID, alive.Round(time.Second))})
+	}
+	return err
 }
 
-func NewWebsocketPool() *WebsocketPool {
+func NewWebsocketPool(url string, clock Clock) *WebsocketPool {
 	return &WebsocketPool{
+		url,
+		clock,
 		[]*WebsocketConnection{},
 		0,
 		[]*WebsocketTopic{},
+		newMessageQueue(),
+		nil,
+		nil,
+		false,
+		0,
+		[]time.Time{},
+		0,
+		time.Time{},
 		nil,
+		time.Time{},
+		sync.Mutex{},
 	}
 }
@@ -0,0 +1,136 @@
+package miner_test
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	miner "github.com/le0developer/go-twitch-channel-point-miner/src"
+	"github.com/le0developer/go-twitch-channel-point-miner/src/simulator"
+)
+
+// newTestPool connects a pool to the PubSub of the simulator and returns a playback topic for each channel of the script
+func newTestPool(t *testing.T, server *simulator.Server) (*miner.WebsocketPool, []*miner.WebsocketTopic) {
+	t.Helper()
+	pool := miner.NewWebsocketPool(server.Endpoints().PubSub, miner.RealClock{})
+	t.Cleanup(func() { _ = pool.Close() })
+
+	topics := []*miner.WebsocketTopic{}
+	for _, channel := range server.Script().Channels {
+		streamer := miner.NewStreamer(channel.Name, channel.ID, nil)
+		topics = append(topics, &miner.WebsocketTopic{Topic: "video-playback-by-id", Streamer: streamer})
+	}
+	return pool, topics
+}
+
+// allListening returns whether twitch confirmed every topic of the pool
+func allListening(pool *miner.WebsocketPool) bool {
+	for _, status := range pool.Topics() {
+		if status.State != miner.TopicStateListening {
+			return false
+		}
+	}
+	return pool.PendingRequests() == 0
+}
+
+func TestWebsocketPoolUnlisten(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}, {Name: "other"}},
+	})
+	pool, topics := newTestPool(t, server)
+
+	if err := pool.Listen(topics...); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the LISTEN", func() bool { return allListening(pool) })
+
+	if err := pool.Unlisten(topics[0]); err != nil {
+		t.Fatal(err)
+	}
+	if topics[0].AssignedTo != nil {
+		t.Error("unlistened topic is still assigned to a connection")
+	}
+	if got := pool.ConnectionTopics(); !slices.EqualFunc(got, [][]string{{"video-playback-by-id.2001"}}, slices.Equal) {
+		t.Errorf("connections have topics %v, want only video-playback-by-id.2001", got)
+	}
+	if got := len(pool.Topics()); got != 1 {
+		t.Errorf("pool has %d topics, want 1", got)
+	}
+	// the RESPONSE to the UNLISTEN is matched by its nonce
+	waitFor(t, "the UNLISTEN", func() bool { return pool.PendingRequests() == 0 })
+
+	if err := pool.Unlisten(topics[0]); err == nil {
+		t.Error("unlistening a topic twice should fail")
+	}
+}
+
+func TestWebsocketPoolRebalance(t *testing.T) {
+	channels := []simulator.ScriptChannel{}
+	for i := range 60 {
+		channels = append(channels, simulator.ScriptChannel{Name: fmt.Sprint("streamer", i)})
+	}
+	server := startSimulator(t, &simulator.Script{Channels: channels})
+	pool, topics := newTestPool(t, server)
+
+	if err := pool.Listen(topics...); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(pool.ConnectionTopics()); got != 2 {
+		t.Fatalf("60 topics use %d connections, want 2", got)
+	}
+	waitFor(t, "the LISTENs", func() bool { return allListening(pool) })
+
+	// the first connection keeps 30 topics, which leaves room for the 10 of the second one
+	if err := pool.Unlisten(topics[:20]...); err != nil {
+		t.Fatal(err)
+	}
+	connections := pool.ConnectionTopics()
+	if len(connections) != 1 {
+		t.Fatalf("rebalancing left %d connections, want 1", len(connections))
+	}
+	want := []string{}
+	for _, topic := range topics[20:] {
+		want = append(want, topic.GetTopicName())
+	}
+	if got := slices.Sorted(slices.Values(connections[0])); !slices.Equal(got, slices.Sorted(slices.Values(want))) {
+		t.Errorf("connection has topics %v, want %v", got, want)
+	}
+	for _, topic := range topics[20:] {
+		if topic.AssignedTo == nil {
+			t.Errorf("%s is not assigned to a connection", topic.GetTopicName())
+		}
+	}
+	waitFor(t, "the moved topics", func() bool { return allListening(pool) })
+}
+
+func TestWebsocketConnectionDisconnectTwice(t *testing.T) {
+	server := startSimulator(t, &simulator.Script{
+		Channels: []simulator.ScriptChannel{{Name: "streamer"}},
+	})
+	pool, topics := newTestPool(t, server)
+	events := miner.NewEventBus()
+	pool.Events = events
+	lost := atomic.Int32{}
+	miner.On(events, func(event miner.ConnectionLost) { lost.Add(1) })
+
+	if err := pool.Listen(topics...); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the LISTEN", func() bool { return allListening(pool) })
+
+	// a missed PONG disconnects from the keepalive, then the reader fails and disconnects again
+	conn := pool.Connections()[0]
+	conn.Disconnect()
+	conn.Disconnect()
+	events.Close()
+	if got := lost.Load(); got != 1 {
+		t.Errorf("published %d ConnectionLost events, want 1", got)
+	}
+	// the connection died right away, so the topic moves to a new connection after backing off once
+	waitFor(t, "a new connection", func() bool { return len(pool.Connections()) == 1 })
+	waitFor(t, "the topic to be listened to again", func() bool { return allListening(pool) })
+	if got := pool.Connections()[0]; got == conn {
+		t.Error("the disconnected connection is still in the pool")
+	}
+}
@@ -0,0 +1,102 @@
+package miner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordedMessage is a single line of a PubSub recording.
+// Either Message or Accounts is set, accounts are written so a recording can be replayed without the original config
+type RecordedMessage struct {
+	Time       time.Time            `json:"time"`
+	Connection int                  `json:"connection"`
+	Message    *RawWebsocketMessage `json:"message,omitempty"`
+	Accounts   *RecordedAccounts    `json:"accounts,omitempty"`
+}
+
+type RecordedAccounts struct {
+	Users     []RecordedAccount `json:"users"`
+	Streamers []RecordedAccount `json:"streamers"`
+}
+
+type RecordedAccount struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// Recorder appends PubSub traffic to a JSONL file
+type Recorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    sync.Mutex
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Record(connection int, message RawWebsocketMessage) error {
+	return r.write(RecordedMessage{Time: time.Now(), Connection: connection, Message: &message})
+}
+
+// RecordAccounts writes the names and IDs of all users and streamers of the miner
+func (r *Recorder) RecordAccounts(miner *Miner) error {
+	miner.Lock.Lock()
+	accounts := RecordedAccounts{}
+	for _, user := range miner.Users {
+		accounts.Users = append(accounts.Users, RecordedAccount{user.Username, user.ID})
+	}
+	for _, streamer := range miner.Streamers {
+		accounts.Streamers = append(accounts.Streamers, RecordedAccount{streamer.Username, streamer.ID})
+	}
+	miner.Lock.Unlock()
+
+	return r.write(RecordedMessage{Time: time.Now(), Connection: -1, Accounts: &accounts})
+}
+
+func (r *Recorder) write(message RecordedMessage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.encoder.Encode(message)
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// ReadRecording reads all lines of a recording
+func ReadRecording(path string) ([]RecordedMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	messages := []RecordedMessage{}
+	scanner := bufio.NewScanner(file)
+	// prediction events can be quite large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var message RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, scanner.Err()
+}
@@ -1,10 +1,23 @@
 package miner
 
+type TopicState = string
+
+const (
+	TopicStatePending   TopicState = "PENDING"
+	TopicStateListening TopicState = "LISTENING"
+	TopicStateFailed    TopicState = "FAILED"
+)
+
 type WebsocketTopic struct {
 	Topic      string
 	User       *User
 	Streamer   *Streamer
 	AssignedTo *WebsocketConnection
+
+	// State is PENDING until twitch confirmed the LISTEN, FAILED topics are not resubmitted
+	State    TopicState
+	Error    string
+	Attempts int
 }
 
 func (topic *WebsocketTopic) GetTopicName() string {
@@ -16,3 +29,12 @@ func (topic *WebsocketTopic) GetTopicName() string {
 	}
 	return topic.Topic
 }
+
+// TopicStatus is a snapshot of a topic for logs and metrics
+type TopicStatus struct {
+	Name       string
+	Username   string
+	State      TopicState
+	Error      string
+	Connection int
+}
@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
-	"strings"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -20,51 +21,72 @@ type WebsocketConnection struct {
 	lastPing    time.Time
 	lastPong    time.Time
 	lastMessage time.Time
+	connectedAt time.Time
+	closed      bool
+
+	// requests waiting for a RESPONSE, by nonce. Guarded by the pool lock
+	pending map[string]*pendingRequest
+
+	// lock guards conn, closed and the timestamps, the reader and keepalive goroutines share them
+	lock sync.Mutex
+}
+
+type pendingRequest struct {
+	messageType string
+	user        *User
+	topics      []*WebsocketTopic
+	sentAt      time.Time
 }
 
 func (ws *WebsocketConnection) Connect() error {
-	conn, err := websocket.Dial(websocketUrl, "", "https://www.twitch.tv")
+	conn, err := websocket.Dial(ws.pool.url, "", "https://www.twitch.tv")
 	if err != nil {
 		return err
 	}
+	ws.lock.Lock()
 	ws.conn = conn
+	ws.connectedAt = ws.pool.clock.Now()
+	ws.lock.Unlock()
 	go ws.HandleMessages()
 	go ws.HandleKeepalive()
 	return nil
 }
 
 func (ws *WebsocketConnection) HandleMessages() {
-	for ws.conn != nil {
+	for ws.getConn() != nil {
 		var data RawWebsocketMessage
 		if err := ws.readMessage(&data); err != nil {
 			fmt.Println("Error reading message", err)
 			break
 		}
+		if ws.pool.Recorder != nil {
+			if err := ws.pool.Recorder.Record(ws.ID, data); err != nil {
+				ws.log("Error recording message", err)
+			}
+		}
 
 		if data.Type == "PONG" {
-			ws.log("Received PONG in", time.Since(ws.lastPing))
-			ws.lastPong = time.Now()
+			ws.lock.Lock()
+			ws.lastPong = ws.pool.clock.Now()
+			rtt := ws.lastPong.Sub(ws.lastPing)
+			ws.lock.Unlock()
+			ws.log("Received PONG in", rtt)
 		} else if data.Type == "RECONNECT" {
 			ws.log("Received signal to reconnect")
 			break
 		} else if data.Type == "RESPONSE" {
-			if data.Error != nil && *data.Error != "" {
-				ws.log("Received error", *data.Error)
-				if strings.Contains(*data.Error, "ERR_BADAUTH") {
-					ws.log("Received ERR_BADAUTH, disconnecting")
-					break
-				}
-			}
+			ws.pool.OnResponse(ws, data)
 		} else if data.Type == "MESSAGE" {
-			ws.lastMessage = time.Now()
+			ws.lock.Lock()
+			ws.lastMessage = ws.pool.clock.Now()
+			ws.lock.Unlock()
 			ws.log("Received message", data.Data.Topic, data.Data.Message)
-			var content WebsocketMessageContent
-			if err := json.Unmarshal([]byte(data.Data.Message), &content); err != nil {
+			message, err := data.Parse()
+			if err != nil {
 				fmt.Println("Error unmarshalling message content", err)
 				break
 			}
-			topics := strings.Split(data.Data.Topic, ".")
-			go (*ws.pool.OnMessage)(WebsocketMessage{topics, content.Type, json.RawMessage(data.Data.Message)})
+			ws.pool.messages.send(message)
 		} else {
 			spew.Dump(data)
 		}
@@ -81,9 +103,9 @@ func (ws *WebsocketConnection) readMessage(data any) error {
 	// so we need to stitch the frames together ourselves until we get a valid json object
 	//
 	// for example a 8kb prediction event is sent in 3 frames
-	for ws.conn != nil {
+	for conn := ws.getConn(); conn != nil; conn = ws.getConn() {
 		var message []byte
-		if err := websocket.Message.Receive(ws.conn, &message); err != nil {
+		if err := websocket.Message.Receive(conn, &message); err != nil {
 			fmt.Println("Error reading message", err)
 			return err
 		}
@@ -103,15 +125,40 @@ func (ws *WebsocketConnection) readMessage(data any) error {
 
 func (ws *WebsocketConnection) Disconnect() {
 	ws.log("Disconnecting from websocket")
-	if ws.conn != nil {
-		_ = ws.conn.WriteClose(1000)
-		ws.conn = nil
+	ws.lock.Lock()
+	conn := ws.conn
+	ws.conn = nil
+	closed := ws.closed
+	ws.lock.Unlock()
+
+	// the reader and the keepalive both disconnect on errors, only the first one tells the pool
+	if conn == nil {
+		return
+	}
+	_ = conn.WriteClose(1000)
+	// closed on purpose by the pool, it already took care of the topics
+	if closed {
+		return
 	}
 	if err := ws.pool.OnDisconnect(ws); err != nil {
 		ws.log("Error handling disconnect in pool", err)
 	}
 }
 
+// Close disconnects without letting the pool resubmit the topics of this connection
+func (ws *WebsocketConnection) Close() {
+	ws.lock.Lock()
+	ws.closed = true
+	ws.lock.Unlock()
+	ws.Disconnect()
+}
+
+func (ws *WebsocketConnection) getConn() *websocket.Conn {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	return ws.conn
+}
+
 func (ws *WebsocketConnection) HandleKeepalive() {
 	// >To keep the server from closing the connection, clients must send a PING command at least once every 5 minutes
 
@@ -124,11 +171,13 @@ func (ws *WebsocketConnection) HandleKeepalive() {
 		return
 	}
 
-	for ws.conn != nil {
+	for conn := ws.getConn(); conn != nil; conn = ws.getConn() {
 		ws.log("Sending PING")
-		ws.lastPing = time.Now()
+		ws.lock.Lock()
+		ws.lastPing = ws.pool.clock.Now()
+		ws.lock.Unlock()
 
-		_, err = ws.conn.Write(encoded)
+		_, err = conn.Write(encoded)
 		if err != nil {
 			fmt.Println("Error sending PING", err)
 			ws.Disconnect()
@@ -136,8 +185,11 @@ func (ws *WebsocketConnection) HandleKeepalive() {
 		}
 
 		//> If a client does not receive a PONG message within 10 seconds of issuing a PING command, it should reconnect to the server
-		time.Sleep(10 * time.Second)
-		if ws.lastPong.Before(ws.lastPing) {
+		ws.pool.clock.Sleep(10 * time.Second)
+		ws.lock.Lock()
+		missed := ws.lastPong.Before(ws.lastPing)
+		ws.lock.Unlock()
+		if missed {
 			ws.log("Did not receive PONG, disconnecting")
 			ws.Disconnect()
 			break
@@ -145,19 +197,20 @@ func (ws *WebsocketConnection) HandleKeepalive() {
 
 		// send next PING in 2 minutes + 0-20 seconds
 		//> If a client uses timers to issue PING commands, it should add a small random jitter to the timer.
-		time.Sleep(2*time.Minute + time.Duration(rand.Intn(20))*time.Second)
+		ws.pool.clock.Sleep(2*time.Minute + time.Duration(rand.Intn(20))*time.Second)
 	}
 }
 
 func (ws *WebsocketConnection) ListenTopics(topics ...*WebsocketTopic) error {
-	users := map[*User][]string{}
+	users := map[*User][]*WebsocketTopic{}
 	for _, topic := range topics {
 		ws.topics = append(ws.topics, topic)
 		topic.AssignedTo = ws
-		users[topic.User] = append(users[topic.User], topic.GetTopicName())
+		topic.State = TopicStatePending
+		users[topic.User] = append(users[topic.User], topic)
 	}
 
-	if ws.conn == nil {
+	if ws.getConn() == nil {
 		err := ws.Connect()
 		if err != nil {
 			return err
@@ -165,29 +218,68 @@ func (ws *WebsocketConnection) ListenTopics(topics ...*WebsocketTopic) error {
 	}
 
 	for user, userTopics := range users {
-		event := map[string]any{
-			"type": "LISTEN",
-			"data": map[string]any{
-				"topics": userTopics,
-			},
-			"nonce": createRandomString(30),
-		}
-		if user != nil {
-			event["data"].(map[string]any)["auth_token"] = user.AuthToken
-		}
-		encoded, err := json.Marshal(event)
-		if err != nil {
+		if err := ws.sendTopics("LISTEN", user, userTopics); err != nil {
 			return err
 		}
-		ws.log("Sending LISTEN", string(encoded))
-		_, err = ws.conn.Write(encoded)
-		if err != nil {
+	}
+	return nil
+}
+
+func (ws *WebsocketConnection) UnlistenTopics(topics ...*WebsocketTopic) error {
+	users := map[*User][]*WebsocketTopic{}
+	for _, topic := range topics {
+		index := slices.Index(ws.topics, topic)
+		if index == -1 {
+			continue
+		}
+		ws.topics = slices.Delete(ws.topics, index, index+1)
+		topic.AssignedTo = nil
+		users[topic.User] = append(users[topic.User], topic)
+	}
+
+	// nothing to tell twitch if we're not connected
+	if ws.getConn() == nil {
+		return nil
+	}
+
+	for user, userTopics := range users {
+		if err := ws.sendTopics("UNLISTEN", user, userTopics); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (ws *WebsocketConnection) sendTopics(messageType string, user *User, topics []*WebsocketTopic) error {
+	names := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		names = append(names, topic.GetTopicName())
+	}
+	nonce := createRandomString(30)
+	event := map[string]any{
+		"type": messageType,
+		"data": map[string]any{
+			"topics": names,
+		},
+		"nonce": nonce,
+	}
+	if user != nil {
+		event["data"].(map[string]any)["auth_token"] = user.AuthToken
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	conn := ws.getConn()
+	if conn == nil {
+		return fmt.Errorf("connection is terminated")
+	}
+	ws.log("Sending", messageType, string(encoded))
+	ws.pending[nonce] = &pendingRequest{messageType, user, topics, ws.pool.clock.Now()}
+	_, err = conn.Write(encoded)
+	return err
+}
+
 func (ws *WebsocketConnection) log(content ...any) {
 	content = append([]any{fmt.Sprintf("[ws-%d]", ws.ID)}, content...)
 	fmt.Println(content...)
@@ -196,5 +288,5 @@ func (ws *WebsocketConnection) log(content ...any) {
 func NewWebsocketConnection(pool *WebsocketPool) *WebsocketConnection {
 	id := pool.connectionIDs
 	pool.connectionIDs++
-	return &WebsocketConnection{id, nil, []*WebsocketTopic{}, pool, time.Time{}, time.Time{}, time.Time{}}
+	return &WebsocketConnection{id, nil, []*WebsocketTopic{}, pool, time.Time{}, time.Time{}, time.Time{}, time.Time{}, false, map[string]*pendingRequest{}, sync.Mutex{}}
 }